@@ -0,0 +1,208 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+)
+
+// API answers on-demand domain checks over HTTP
+type API struct {
+	source func() ROASource
+	cache  *ResultCache
+	rate   *RateLimiter
+	checks limiter
+}
+
+// ResultCache keeps domain results for a fixed time
+type ResultCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	swept   time.Time
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  *DomainResult
+	expires time.Time
+}
+
+// RateLimiter is a token bucket per client address
+type RateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newAPI(source func() ROASource, cache *ResultCache, rate float64, burst int, checks int) *API {
+	return &API{
+		source: source,
+		cache:  cache,
+		rate:   newRateLimiter(rate, burst),
+		checks: newLimiter(checks),
+	}
+}
+
+// register adds the API endpoints to mux
+func (a *API) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/domain/{name}", a.domain)
+}
+
+// domain returns the result of a single domain as json
+func (a *API) domain(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSuffix(r.PathValue("name"), "."))
+	if _, ok := dns.IsDomainName(name); !ok || name == "" {
+		apiError(w, http.StatusBadRequest, "invalid domain name")
+		return
+	}
+
+	if !a.rate.allow(clientAddress(r)) {
+		w.Header().Set("Retry-After", "1")
+		apiError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	result, ok := a.cache.get(name)
+	if ok {
+		w.Header().Set("X-Cache", "hit")
+	} else {
+		if !a.checks.tryAcquire() {
+			w.Header().Set("Retry-After", "1")
+			apiError(w, http.StatusServiceUnavailable, "too many checks in progress")
+			return
+		}
+		log.Debugf("API check of %s for %s", name, clientAddress(r))
+		var err error
+		result, err = domainStat(name, a.source())
+		a.checks.release()
+		if err != nil || result.Stat.failed() {
+			// do not keep failed measurements
+			log.Debugf("API check of %s failed: %v", name, result.Stat.Reasons)
+		} else {
+			a.cache.put(result)
+		}
+		w.Header().Set("X-Cache", "miss")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error writing API response: %s", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// clientAddress returns the remote address of r without port
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func newResultCache(ttl time.Duration) *ResultCache {
+	return &ResultCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func (c *ResultCache) get(domain string) (*DomainResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[domain]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores result, expired entries are dropped once per ttl
+func (c *ResultCache) put(result *DomainResult) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) > c.ttl {
+		for domain, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, domain)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[result.Stat.Domain] = cacheEntry{result: result, expires: now.Add(c.ttl)}
+}
+
+// newRateLimiter allows rate requests per second and client with bursts
+// up to burst requests. A rate of zero or less does not limit anything.
+func newRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+func (l *RateLimiter) allow(client string) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		// full buckets carry no state, forget them
+		for c, other := range l.buckets {
+			if other.tokens+now.Sub(other.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, c)
+			}
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
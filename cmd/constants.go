@@ -17,6 +17,8 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -45,13 +47,81 @@ const ROUTINATOR_SHORT = "r"
 
 const RESOLVER string = "resolver"
 const RESOLVER_SHORT string = "s"
+const RESOLVER_MODE string = "resolvermode"
+
+const VRPS string = "vrps"
+
+const RTR string = "rtr"
+
+const PFX2AS string = "pfx2as"
+const PFX2AS_SHORT string = "p"
+
+const DETAIL string = "detail"
+
+const OUTPUT string = "output"
+const OUTPUT_SHORT string = "o"
+const OUTPUT_FILE string = "outfile"
+
+const OUTPUT_TEXT string = "text"
+const OUTPUT_JSON string = "json"
+const OUTPUT_NDJSON string = "ndjson"
+const OUTPUT_CSV string = "csv"
+
+const WORKERS string = "workers"
+const WORKERS_SHORT string = "w"
+
+const DNS_LIMIT string = "dnslimit"
+const ROUTINATOR_LIMIT string = "routinatorlimit"
+
+const LISTEN string = "listen"
+const SCHEDULE string = "schedule"
+const LOCK_FILE string = "lockfile"
+
+const RESUME string = "resume"
+const BATCH string = "batch"
+
+const HTTP string = "http"
+const CACHE_TTL string = "cachettl"
+const HTTP_RATE string = "httprate"
+const HTTP_BURST string = "httpburst"
+const HTTP_LIMIT string = "httplimit"
+
+const DELEGATION string = "delegation"
+const NS_SET string = "nsset"
+const ROOTS string = "roots"
+
+const PROBE string = "probe"
 
 const TIMEOUT = 3
 
+const ROUTINATOR_TIMEOUT = 10
+
 func init() {
 
 	// Set defaults
 	//
 	// default log loglevel
 	viper.SetDefault(VERBOSE, VERBOSE_QUIET)
+
+	// default concurrency
+	viper.SetDefault(WORKERS, 10)
+	viper.SetDefault(DNS_LIMIT, 50)
+	viper.SetDefault(ROUTINATOR_LIMIT, 10)
+
+	// default number of domains saved per transaction
+	viper.SetDefault(BATCH, 100)
+
+	// default use of several resolvers
+	viper.SetDefault(RESOLVER_MODE, RESOLVER_ROUNDROBIN)
+
+	// default NS set of the statistics
+	viper.SetDefault(NS_SET, NS_SET_CHILD)
+
+	// default serve mode
+	viper.SetDefault(LISTEN, ":9323")
+	viper.SetDefault(SCHEDULE, "1h")
+	viper.SetDefault(CACHE_TTL, 10*time.Minute)
+	viper.SetDefault(HTTP_RATE, 1.0)
+	viper.SetDefault(HTTP_BURST, 5)
+	viper.SetDefault(HTTP_LIMIT, 4)
 }
@@ -21,47 +21,147 @@ import (
 	"github.com/spf13/viper"
 	"github.com/apex/log"
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"errors"
+	"fmt"
+	"strings"
 )
 
-func openDB() *sql.DB {
+// openDB connects to the database and checks the schema version
+func openDB() (*Storage, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func connectDB() (*Storage, error) {
 	// open database
 	if viper.GetString(DBCREDENTIALS) == "" {
-		log.Fatal("No DB credentials given.")
+		return nil, errors.New("no DB credentials given")
 	}
-	db, err := sql.Open("mysql", viper.GetString(DBCREDENTIALS))
+	db, err := openStorage(viper.GetString(DBCREDENTIALS))
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
 	err = db.Ping()
 	if err != nil {
-		log.Fatal(err.Error())
+		db.Close()
+		return nil, err
 	}
-	log.Debug("DB OPEN")
-	return db
+	log.Debugf("DB OPEN (%s)", db.dialect.Name())
+	return db, nil
 }
 
-func rpki2db(db *sql.DB, rpkistats []*RPKIstat) {
+// rpki2db saves a batch of results of run and moves the checkpoint of the
+// run past them in the same transaction. Every column written here needs a
+// migration, openDB refuses databases that have not been migrated.
+func rpki2db(db *Storage, run *Run, results []*DomainResult, detail bool) error {
 
 	tx, err := db.Begin()
 	if err != nil {
-		log.Fatalf("Could not start DB transaction %s", err)
+		return fmt.Errorf("could not start DB transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	for _,rpki := range rpkistats {
-		_, err = tx.Exec("INSERT INTO RPKI(TESTDATE,TLD,NAMES,NAMES_ROA_FULL,NAMES_ROA_PARTIAL,IP4S,IP4S_ROAS,IP6s, IP6S_ROAS,TAS4, TAS6, AS4, AS6) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", 
-		                  rpki.Date, rpki.Domain, rpki.Names, rpki.NamesFull, rpki.NamesPartial, rpki.IPv4, rpki.IPv4roas, rpki.IPv6, rpki.IPv6roas, rpki.TAs4, rpki.TAs6, rpki.AS4, rpki.AS6)
-		log.Debugf("INSERT INTO RPKI %s, %-15s, Names %2d, Full %2d, Partail %2d, IPv4 %2d, ROA %2d, IPv6 %2d, ROA %2d, TA4 %1d, TA6 %1d, AS4 %2d, AS6 %2d", 
-		                  rpki.Date, rpki.Domain, rpki.Names, rpki.NamesFull, rpki.NamesPartial, rpki.IPv4, rpki.IPv4roas, rpki.IPv6, rpki.IPv6roas, rpki.TAs4, rpki.TAs6, rpki.AS4, rpki.AS6)
+	for _,result := range results {
+		rpki := result.Stat
+		id, err := tx.Insert("INSERT INTO RPKI(TESTDATE,TLD,NAMES,NAMES_ROA_FULL,NAMES_ROA_PARTIAL,IP4S,IP4S_ROAS,IP6s, IP6S_ROAS,TAS4, TAS6, AS4, AS6, IP4S_VALID, IP4S_INVALID_AS, IP4S_INVALID_LENGTH, IP4S_NOT_FOUND, IP6S_VALID, IP6S_INVALID_AS, IP6S_INVALID_LENGTH, IP6S_NOT_FOUND, IP4S_UNKNOWN, IP6S_UNKNOWN, IP4S_MAXLEN_INVALID, IP6S_MAXLEN_INVALID, IP4S_MAXLEN_PERMISSIVE, IP6S_MAXLEN_PERMISSIVE, ERRORS_SERVFAIL, ERRORS_TIMEOUT, ERRORS_NXDOMAIN, ERRORS_DNS, ERRORS_ROA, STATUS, REASONS, NS_SET, PARENT_ONLY, CHILD_ONLY, PARENT_COVERAGE, CHILD_COVERAGE, GLUE_NAMES, GLUE_MISMATCH, IP4S_SERVING, IP4S_SERVING_ROAS, IP6S_SERVING, IP6S_SERVING_ROAS, SOA_SERIALS, RESOLVER_DIFFERENCES, RUN_ID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", 
+		                  rpki.Date, rpki.Domain, rpki.Names, rpki.NamesFull, rpki.NamesPartial, rpki.IPv4, rpki.IPv4roas, rpki.IPv6, rpki.IPv6roas, rpki.TAs4, rpki.TAs6, rpki.AS4, rpki.AS6,
+		                  rpki.IPv4valid, rpki.IPv4invalidAS, rpki.IPv4invalidLength, rpki.IPv4notFound, rpki.IPv6valid, rpki.IPv6invalidAS, rpki.IPv6invalidLength, rpki.IPv6notFound, rpki.IPv4unknown, rpki.IPv6unknown, rpki.IPv4maxLengthInvalid, rpki.IPv6maxLengthInvalid, rpki.IPv4maxLengthPermissive, rpki.IPv6maxLengthPermissive,
+		                  rpki.ErrServfail, rpki.ErrTimeout, rpki.ErrNxdomain, rpki.ErrDNS, rpki.ErrROA, rpki.Status, strings.Join(rpki.Reasons, ","), rpki.NSSet, rpki.ParentOnly, rpki.ChildOnly, nullString(rpki.ParentCoverage), nullString(rpki.ChildCoverage), rpki.GlueNames, rpki.GlueMismatch,
+		                  nullInt(rpki.IPv4serving, rpki.Probed), nullInt(rpki.IPv4servingROAs, rpki.Probed), nullInt(rpki.IPv6serving, rpki.Probed), nullInt(rpki.IPv6servingROAs, rpki.Probed), nullInt(rpki.Serials, rpki.Probed), nullInt(rpki.ResolverDifferences, rpki.ResolversCompared), run.ID)
+		log.Debugf("INSERT INTO RPKI %s, %-15s, Names %2d, Full %2d, Partail %2d, IPv4 %2d, ROA %2d, IPv6 %2d, ROA %2d, TA4 %1d, TA6 %1d, AS4 %2d, AS6 %2d, Valid4 %2d, Invalid4 %2d/%2d, NotFound4 %2d, Valid6 %2d, Invalid6 %2d/%2d, NotFound6 %2d, Unknown4 %2d, Unknown6 %2d, MaxLenInvalid4 %2d, MaxLenInvalid6 %2d, Permissive4 %2d, Permissive6 %2d, Errors %d/%d/%d/%d/%d, Status %s %v, NS set %s %d/%d, Glue %d/%d", 
+		                  rpki.Date, rpki.Domain, rpki.Names, rpki.NamesFull, rpki.NamesPartial, rpki.IPv4, rpki.IPv4roas, rpki.IPv6, rpki.IPv6roas, rpki.TAs4, rpki.TAs6, rpki.AS4, rpki.AS6,
+		                  rpki.IPv4valid, rpki.IPv4invalidAS, rpki.IPv4invalidLength, rpki.IPv4notFound, rpki.IPv6valid, rpki.IPv6invalidAS, rpki.IPv6invalidLength, rpki.IPv6notFound, rpki.IPv4unknown, rpki.IPv6unknown, rpki.IPv4maxLengthInvalid, rpki.IPv6maxLengthInvalid, rpki.IPv4maxLengthPermissive, rpki.IPv6maxLengthPermissive,
+		                  rpki.ErrServfail, rpki.ErrTimeout, rpki.ErrNxdomain, rpki.ErrDNS, rpki.ErrROA, rpki.Status, rpki.Reasons, rpki.NSSet, rpki.ParentOnly, rpki.ChildOnly, rpki.GlueNames, rpki.GlueMismatch)
 		if err != nil {
-			log.Fatalf("Could not insert into %s", err)
+			return fmt.Errorf("could not insert into RPKI: %w", err)
 		}
+		if !detail {
+			continue
+		}
+		if err = detail2db(tx, id, result); err != nil {
+			return err
+		}
+	}
+
+	done := run.Done + len(results)
+	dnsErrors, roaErrors := countErrors(results)
+	_, err = tx.Exec("UPDATE RUNS SET DONE = ?, DNS_ERRORS = ?, ROA_ERRORS = ? WHERE ID = ?", done, run.DNSErrors+dnsErrors, run.ROAErrors+roaErrors, run.ID)
+	if err != nil {
+		return fmt.Errorf("could not update RUNS: %w", err)
 	}
+
 	err = tx.Commit()
 	if err != nil {
-		log.Fatalf("Could not commit to DB %s", err)
+		return fmt.Errorf("could not commit to DB: %w", err)
 	} 
-	log.Debug("Data committed to database")
-	return
+	run.Done = done
+	run.addErrors(results)
+	log.Debugf("Data committed to database, %d domains done", run.Done)
+	return nil
+}
+
+// detail2db saves nameservers, addresses and ROAs of a result in child tables of RPKI
+func detail2db(tx *StorageTx, rpkiID int64, result *DomainResult) error {
+	for _, ns := range result.Nameservers {
+		nsID, err := tx.Insert("INSERT INTO RPKI_NS(RPKI_ID, NAME, COVERAGE, ERROR, GLUE_MISMATCH) VALUES (?, ?, ?, ?, ?)", rpkiID, ns.Name, ns.Coverage, sql.NullString{String: ns.Error, Valid: ns.Error != ""}, ns.GlueMismatch)
+		if err != nil {
+			return fmt.Errorf("could not insert into RPKI_NS: %w", err)
+		}
+		log.Debugf("INSERT INTO RPKI_NS %d, %s, %s, %s", rpkiID, ns.Name, ns.Coverage, ns.Error)
+
+		for _, addr := range ns.Addresses {
+			var asns, tas string
+			var hasROA, maxlenInvalid, permissive bool
+			if addr.Roa != nil {
+				hasROA = true
+				asns = strings.Join(addr.Roa.Asn, ",")
+				tas = strings.Join(addr.Roa.Ta, ",")
+				maxlenInvalid = addr.Roa.MaxLengthInvalid
+				permissive = addr.Roa.MaxLengthPermissive
+			}
+			var origin sql.NullInt64
+			var validity, reason sql.NullString
+			if addr.Validity != nil {
+				origin = sql.NullInt64{Int64: int64(addr.Validity.Origin), Valid: true}
+				validity = sql.NullString{String: addr.Validity.State, Valid: true}
+				reason = sql.NullString{String: addr.Validity.Reason, Valid: addr.Validity.Reason != ""}
+			}
+			var answered, authoritative sql.NullBool
+			var serial sql.NullInt64
+			var rtt sql.NullFloat64
+			var probeError sql.NullString
+			if addr.Probe != nil {
+				answered = sql.NullBool{Bool: addr.Probe.Answered, Valid: true}
+				authoritative = sql.NullBool{Bool: addr.Probe.Authoritative, Valid: true}
+				serial = sql.NullInt64{Int64: int64(addr.Probe.Serial), Valid: addr.Probe.Answered}
+				rtt = sql.NullFloat64{Float64: addr.Probe.RTT, Valid: addr.Probe.Answered}
+				probeError = nullString(addr.Probe.Error)
+			}
+			addrID, err := tx.Insert("INSERT INTO RPKI_ADDRESS(RPKI_NS_ID, IP, PREFIX, PREFIX_SOURCE, ROA, ASNS, TAS, MAXLEN_INVALID, MAXLEN_PERMISSIVE, ORIGIN, VALIDITY, VALIDITY_REASON, UNKNOWN, SOURCE, ANSWERED, AUTHORITATIVE, SERIAL, RTT, PROBE_ERROR) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				nsID, addr.Ip, addr.Prefix, addr.PrefixSource, hasROA, asns, tas, maxlenInvalid, permissive, origin, validity, reason, addr.Unknown, addr.Source,
+				answered, authoritative, serial, rtt, probeError)
+			if err != nil {
+				return fmt.Errorf("could not insert into RPKI_ADDRESS: %w", err)
+			}
+			log.Debugf("INSERT INTO RPKI_ADDRESS %d, %s, %s, %s, ROA %t", nsID, addr.Ip, addr.Prefix, addr.PrefixSource, hasROA)
+			if addr.Roa == nil {
+				continue
+			}
+			for _, vrp := range addr.Roa.Vrps {
+				_, err = tx.Exec("INSERT INTO RPKI_ROA(RPKI_ADDRESS_ID, PREFIX, MAXLENGTH, ASN, TA) VALUES (?, ?, ?, ?, ?)",
+					addrID, vrp.Prefix.String(), vrp.MaxLength, vrp.ASN, vrp.TA)
+				if err != nil {
+					return fmt.Errorf("could not insert into RPKI_ROA: %w", err)
+				}
+			}
+		}
+	}
+	return nil
 }
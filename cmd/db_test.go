@@ -0,0 +1,107 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the RPKI table as created before rpkistats had migrations
+const legacyRPKITable = `CREATE TABLE RPKI (TESTDATE DATETIME, TLD VARCHAR(255), NAMES INT, NAMES_ROA_FULL INT, NAMES_ROA_PARTIAL INT,
+	IP4S INT, IP4S_ROAS INT, IP6S INT, IP6S_ROAS INT, TAS4 INT, TAS6 INT, AS4 INT, AS6 INT)`
+
+// testStorage returns an empty SQLite database
+func testStorage(t *testing.T) *Storage {
+	t.Helper()
+	db, err := openStorage("sqlite://" + filepath.Join(t.TempDir(), "rpki.db"))
+	if err != nil {
+		t.Fatalf("openStorage: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testDB returns a SQLite database with the latest schema
+func testDB(t *testing.T) *Storage {
+	t.Helper()
+	db := testStorage(t)
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("migrateDB: %s", err)
+	}
+	return db
+}
+
+func testResult() *DomainResult {
+	vrp := VRP{Prefix: netip.MustParsePrefix("192.0.2.0/24"), MaxLength: 24, ASN: 64496, TA: "ripe"}
+	return &DomainResult{
+		Stat: &RPKIstat{Domain: "example", Date: time.Now().UTC(), Names: 1, NamesFull: 1, IPv4: 1, IPv4roas: 1, TAs4: 1, AS4: 1, IPv4valid: 1, Status: STATUS_OK, NSSet: NS_SET_CHILD},
+		Nameservers: []*NameserverResult{{
+			Name:     "ns1.example.net.",
+			Coverage: COVERAGE_FULL,
+			Addresses: []*AddressResult{{
+				Ip:           "192.0.2.1",
+				Prefix:       "192.0.2.0/24",
+				PrefixSource: PREFIX_ANNOUNCED,
+				Roa:          &ROA{Asn: []string{"64496"}, Ta: []string{"ripe"}, Vrps: []VRP{vrp}},
+				Validity:     &Validity{Prefix: "192.0.2.0/24", Origin: 64496, State: VALIDITY_VALID},
+				Source:       ADDRESS_RESOLVED,
+			}},
+		}},
+	}
+}
+
+// every column written by rpki2db and detail2db must exist after migrating
+func TestRPKI2DBSchema(t *testing.T) {
+	db := testDB(t)
+	run := &Run{Started: time.Now().UTC(), Resolver: "127.0.0.1", ROASource: SourceMetadata{Name: "test"}, InputFile: "test.list", Version: "test"}
+	if err := startRun2db(db, run); err != nil {
+		t.Fatalf("startRun2db: %s", err)
+	}
+	for _, detail := range []bool{false, true} {
+		if err := rpki2db(db, run, []*DomainResult{testResult()}, detail); err != nil {
+			t.Fatalf("rpki2db detail %t: %s", detail, err)
+		}
+	}
+	if run.Done != 2 {
+		t.Errorf("run.Done = %d, want 2", run.Done)
+	}
+	for table, want := range map[string]int{"RPKI": 2, "RPKI_NS": 1, "RPKI_ADDRESS": 1, "RPKI_ROA": 1} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatalf("count %s: %s", table, err)
+		}
+		if count != want {
+			t.Errorf("%s has %d rows, want %d", table, count, want)
+		}
+	}
+}
+
+// a database without schema version is refused before anything is written
+func TestCheckSchemaLegacy(t *testing.T) {
+	db := testStorage(t)
+	if _, err := db.Exec(legacyRPKITable); err != nil {
+		t.Fatalf("create legacy table: %s", err)
+	}
+	err := checkSchema(db)
+	if err == nil || !strings.Contains(err.Error(), "db migrate") {
+		t.Fatalf("checkSchema = %v, want outdated schema error", err)
+	}
+}
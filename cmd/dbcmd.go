@@ -0,0 +1,100 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+
+	"github.com/spf13/cobra"
+)
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the database schema",
+	Long: `Create and update the database schema used to save RPKI statistics.
+The backend (MySQL/MariaDB, PostgreSQL or SQLite) is selected by the
+scheme of dbcredentials.`,
+}
+
+// dbMigrateCmd represents the db migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Update the database schema to the latest version",
+	Long: `Apply all migrations not yet applied to the database.
+An RPKI table created before rpkistats had migrations is adopted,
+it gets an ID column (MySQL/MariaDB and PostgreSQL only).`,
+	Run: execDBMigrate,
+}
+
+// dbStatusCmd represents the db status command
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the database schema version",
+	Long:  `Show applied and pending migrations of the database schema`,
+	Run:   execDBStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+}
+
+func execDBMigrate(cmd *cobra.Command, args []string) {
+	db, err := connectDB()
+	if err != nil {
+		log.Fatalf("Could not open database: %s", err)
+	}
+	defer db.Close()
+	if err := migrateDB(db); err != nil {
+		log.Fatal(err.Error())
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fmt.Printf("Schema version %d\n", version)
+}
+
+func execDBStatus(cmd *cobra.Command, args []string) {
+	db, err := connectDB()
+	if err != nil {
+		log.Fatalf("Could not open database: %s", err)
+	}
+	defer db.Close()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	done := make(map[int]AppliedMigration)
+	version := 0
+	for _, a := range applied {
+		done[a.Version] = a
+		version = a.Version
+	}
+	fmt.Printf("Schema version %d (latest %d)\n", version, latestSchemaVersion(db.dialect))
+	for _, m := range loadMigrations(db.dialect) {
+		if a, ok := done[m.version]; ok {
+			fmt.Printf("  %04d %-20s applied %s\n", m.version, m.name, a.Applied.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("  %04d %-20s pending\n", m.version, m.name)
+		}
+	}
+}
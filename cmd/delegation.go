@@ -0,0 +1,264 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+	"github.com/spf13/viper"
+)
+
+// NS set a statistic is measured on
+const NS_SET_CHILD string = "child"
+const NS_SET_PARENT string = "parent"
+
+// maximum number of referrals followed from the root
+const DELEGATION_DEPTH = 16
+
+// addresses of the root servers, IPv4 first
+var defaultRoots = []string{
+	"198.41.0.4", "170.247.170.2", "192.33.4.12", "199.7.91.13", "192.203.230.10", "192.5.5.241", "192.112.36.4",
+	"198.97.190.53", "192.36.148.17", "192.58.128.30", "193.0.14.129", "199.7.83.42", "202.12.27.33",
+	"2001:503:ba3e::2:30", "2801:1b8:10::b", "2001:500:2::c", "2001:500:2d::d", "2001:500:a8::e", "2001:500:2f::f", "2001:500:12::d0d",
+	"2001:500:1::53", "2001:7fe::53", "2001:503:c27::2:30", "2001:7fd::1", "2001:500:9f::42", "2001:dc3::35",
+}
+
+// Delegation is the NS set of a domain as published in its parent zone
+type Delegation struct {
+	// the parent zone and the server that answered
+	Zone   string
+	Server string
+	// lower case names of the NS set
+	Nameservers []string
+	// addresses of the NS set given as additional data
+	Glue map[string][]string
+}
+
+// DelegationResult compares the NS set of the parent with the NS set of the child
+type DelegationResult struct {
+	Zone       string              `json:"zone"`
+	Server     string              `json:"server"`
+	Parent     []string            `json:"parent"`
+	Child      []string            `json:"child"`
+	ParentOnly []string            `json:"parent_only"`
+	ChildOnly  []string            `json:"child_only"`
+	Glue       map[string][]string `json:"glue"`
+}
+
+// delegationEnabled is true if the parent has to be asked for the NS set
+func delegationEnabled() bool {
+	return viper.GetBool(DELEGATION) || viper.GetString(NS_SET) == NS_SET_PARENT
+}
+
+// getDelegation follows referrals from the root servers down to the parent
+// of domain and returns the delegation found there. Addresses of nameservers
+// without glue are looked up with the resolver.
+func getDelegation(domain string) (*Delegation, error) {
+	name := strings.ToLower(dns.Fqdn(domain))
+	delegation := &Delegation{Zone: ".", Nameservers: make([]string, 0), Glue: make(map[string][]string)}
+
+	servers := viper.GetStringSlice(ROOTS)
+	if len(servers) == 0 {
+		servers = defaultRoots
+	}
+	for depth := 0; depth < DELEGATION_DEPTH; depth++ {
+		msg, server, err := queryServers(servers, name, dns.TypeNS)
+		if err != nil {
+			return delegation, err
+		}
+		delegation.Server = server
+
+		if msg.Rcode == dns.RcodeNameError {
+			return delegation, &DNSError{Name: name, Qtype: dns.TypeNS, Category: ERROR_NXDOMAIN, Err: fmt.Errorf("rcode NXDOMAIN from %s", server)}
+		}
+
+		// a server of the parent is also authoritative for the child
+		if answer := nsRecords(msg.Answer, name); len(answer) > 0 {
+			delegation.Nameservers = answer
+			delegation.Glue = glue(msg.Extra, answer)
+			return delegation, nil
+		}
+
+		// follow the referral, it must come closer to domain
+		cut, referral := referral(msg.Ns)
+		if len(referral) == 0 || !dns.IsSubDomain(cut, name) || dns.CountLabel(cut) <= dns.CountLabel(delegation.Zone) {
+			return delegation, &DNSError{Name: name, Qtype: dns.TypeNS, Category: ERROR_DNS, Err: fmt.Errorf("no delegation from %s (zone %s)", server, delegation.Zone)}
+		}
+		if cut == name {
+			delegation.Nameservers = referral
+			delegation.Glue = glue(msg.Extra, referral)
+			return delegation, nil
+		}
+		log.Debugf("%s: referral from %s to %s", name, delegation.Zone, cut)
+		delegation.Zone = cut
+		servers = referralServers(referral, glue(msg.Extra, referral))
+		if len(servers) == 0 {
+			return delegation, &DNSError{Name: name, Qtype: dns.TypeNS, Category: ERROR_DNS, Err: fmt.Errorf("no addresses for the nameservers of %s", cut)}
+		}
+	}
+	return delegation, &DNSError{Name: name, Qtype: dns.TypeNS, Category: ERROR_DNS, Err: fmt.Errorf("more than %d referrals", DELEGATION_DEPTH)}
+}
+
+// queryServers sends a non-recursive query to one server after the other
+// until one of them answers with NOERROR or NXDOMAIN
+func queryServers(servers []string, name string, qtype uint16) (*dns.Msg, string, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	query.RecursionDesired = false
+
+	client := new(dns.Client)
+	client.ReadTimeout = TIMEOUT * 1e9
+	client.Net = "tcp"
+
+	var lastErr error = &DNSError{Name: name, Qtype: qtype, Category: ERROR_DNS, Err: errors.New("no servers")}
+	for _, server := range servers {
+		address := net.JoinHostPort(server, "53")
+		dnsLimit.acquire()
+		r, _, err := client.Exchange(query, address)
+		dnsLimit.release()
+
+		if err != nil {
+			log.Debugf("%-30s: Error asking %s: %s", name, address, err)
+			category := ERROR_DNS
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				category = ERROR_TIMEOUT
+			}
+			lastErr = &DNSError{Name: name, Qtype: qtype, Category: category, Err: err}
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			log.Debugf("%-30s: %s from %s", name, dns.RcodeToString[r.Rcode], address)
+			category := ERROR_DNS
+			if r.Rcode == dns.RcodeServerFailure {
+				category = ERROR_SERVFAIL
+			}
+			lastErr = &DNSError{Name: name, Qtype: qtype, Category: category, Err: fmt.Errorf("rcode %s from %s", dns.RcodeToString[r.Rcode], address)}
+			continue
+		}
+		return r, server, nil
+	}
+	return nil, "", lastErr
+}
+
+// nsRecords returns the lower case NS names of owner in rrs
+func nsRecords(rrs []dns.RR, owner string) []string {
+	names := make([]string, 0)
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, owner) {
+			names = append(names, strings.ToLower(ns.Ns))
+		}
+	}
+	names = unique(names)
+	sort.Strings(names)
+	return names
+}
+
+// referral returns the zone cut and NS names of the authority section
+func referral(rrs []dns.RR) (string, []string) {
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok {
+			cut := strings.ToLower(ns.Hdr.Name)
+			return cut, nsRecords(rrs, cut)
+		}
+	}
+	return "", nil
+}
+
+// glue returns the addresses of nameservers in the additional section
+func glue(extra []dns.RR, nameservers []string) map[string][]string {
+	wanted := make(map[string]bool, len(nameservers))
+	for _, ns := range nameservers {
+		wanted[ns] = true
+	}
+	result := make(map[string][]string)
+	for _, rr := range extra {
+		name := strings.ToLower(rr.Header().Name)
+		if !wanted[name] {
+			continue
+		}
+		switch a := rr.(type) {
+		case *dns.A:
+			result[name] = append(result[name], a.A.String())
+		case *dns.AAAA:
+			result[name] = append(result[name], a.AAAA.String())
+		}
+	}
+	for name := range result {
+		result[name] = unique(result[name])
+		sort.Strings(result[name])
+	}
+	return result
+}
+
+// referralServers returns the addresses of the nameservers of a referral,
+// glue is used if given, otherwise the resolver is asked
+func referralServers(nameservers []string, glue map[string][]string) []string {
+	servers := make([]string, 0)
+	for _, ns := range nameservers {
+		servers = append(servers, glue[ns]...)
+	}
+	if len(servers) > 0 {
+		return servers
+	}
+	for _, ns := range nameservers {
+		ip4, _ := getIP4(ns)
+		ip6, _ := getIP6(ns)
+		servers = append(append(servers, ip4...), ip6...)
+	}
+	return servers
+}
+
+// compareDelegation lists the names only found in the parent or only in the child
+func compareDelegation(delegation *Delegation, child []string) *DelegationResult {
+	result := &DelegationResult{
+		Zone:       delegation.Zone,
+		Server:     delegation.Server,
+		Parent:     delegation.Nameservers,
+		Child:      make([]string, 0, len(child)),
+		ParentOnly: make([]string, 0),
+		ChildOnly:  make([]string, 0),
+		Glue:       delegation.Glue,
+	}
+	inParent := make(map[string]bool, len(delegation.Nameservers))
+	for _, ns := range delegation.Nameservers {
+		inParent[ns] = true
+	}
+	inChild := make(map[string]bool, len(child))
+	for _, ns := range child {
+		ns = strings.ToLower(ns)
+		inChild[ns] = true
+		result.Child = append(result.Child, ns)
+		if !inParent[ns] {
+			result.ChildOnly = append(result.ChildOnly, ns)
+		}
+	}
+	for _, ns := range delegation.Nameservers {
+		if !inChild[ns] {
+			result.ParentOnly = append(result.ParentOnly, ns)
+		}
+	}
+	sort.Strings(result.Child)
+	sort.Strings(result.ChildOnly)
+	return result
+}
@@ -0,0 +1,514 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/spf13/cobra"
+)
+
+const DIFF_FROM string = "from"
+const DIFF_TO string = "to"
+const DIFF_THRESHOLD string = "threshold"
+
+// exit code if regressions exceed the threshold
+const DIFF_EXIT_REGRESSION = 2
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare two result sets",
+	Long: `Compare two result sets domain by domain and report coverage regressions.
+
+A result set is given as
+  a run id          results of this run from the database
+  a date YYYY-MM-DD the last result per domain of this day from the database
+  a file name       results written by "run --output ndjson" (with --detail for address data)
+
+Nameserver addresses, ASNs and TAs are only compared if detailed results
+are available ("run --detail"). Domains whose measurement failed in
+either set are listed as failed and not compared. The exit code is 2
+if the number of regressions exceeds the threshold.`,
+	Run: execDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	// diff flags are read from the command, they are not configuration
+	diffCmd.Flags().String(DIFF_FROM, "", "older result set (run id, date or ndjson file)")
+	diffCmd.Flags().String(DIFF_TO, "", "newer result set (run id, date or ndjson file)")
+	diffCmd.Flags().Int(DIFF_THRESHOLD, 0, "number of regressions tolerated before exiting with code 2")
+	diffCmd.Flags().StringP(OUTPUT, OUTPUT_SHORT, OUTPUT_TEXT, "output format: text or json")
+}
+
+// CoverageRegression is a domain whose coverage changed
+type CoverageRegression struct {
+	Domain string `json:"domain"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// LostROA is a nameserver address that had a ROA before
+type LostROA struct {
+	Domain     string `json:"domain"`
+	Nameserver string `json:"nameserver"`
+	Ip         string `json:"ip"`
+}
+
+// NewItems lists ASNs or TAs a domain did not use before
+type NewItems struct {
+	Domain string   `json:"domain"`
+	Items  []string `json:"items"`
+}
+
+// FailedMeasurement is a domain that could not be compared
+type FailedMeasurement struct {
+	Domain  string   `json:"domain"`
+	Before  string   `json:"before"`
+	After   string   `json:"after"`
+	Reasons []string `json:"reasons"`
+}
+
+// Diff is the comparison of two result sets
+type Diff struct {
+	From         string                `json:"from"`
+	To           string                `json:"to"`
+	Regressions  []*CoverageRegression `json:"regressions"`
+	Improvements []*CoverageRegression `json:"improvements"`
+	LostROAs     []*LostROA            `json:"lost_roas"`
+	NewASNs      []*NewItems           `json:"new_asns"`
+	NewTAs       []*NewItems           `json:"new_tas"`
+	Failed       []*FailedMeasurement  `json:"failed"`
+	Removed      []string              `json:"removed"`
+	Added        []string              `json:"added"`
+}
+
+// RegressionCount is compared to the threshold
+func (d *Diff) RegressionCount() int {
+	return len(d.Regressions) + len(d.LostROAs)
+}
+
+func execDiff(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetString(DIFF_FROM)
+	to, _ := cmd.Flags().GetString(DIFF_TO)
+	threshold, _ := cmd.Flags().GetInt(DIFF_THRESHOLD)
+	format, _ := cmd.Flags().GetString(OUTPUT)
+
+	if from == "" || to == "" {
+		cmd.Help()
+		log.Fatal("From and to must be given.")
+	}
+	if format != OUTPUT_TEXT && format != OUTPUT_JSON {
+		cmd.Help()
+		log.Fatalf("Unknown output format %s", format)
+	}
+
+	// the database is only opened if needed
+	var db *Storage
+	load := func(spec string) map[string]*DomainResult {
+		if _, err := os.Stat(spec); err == nil {
+			return loadResultFile(spec)
+		}
+		if db == nil {
+			var err error
+			db, err = openDB()
+			if err != nil {
+				log.Fatalf("Could not open database: %s", err)
+			}
+		}
+		results, err := loadResultSet(db, spec)
+		if err != nil {
+			log.Fatalf("Could not load results %s: %s", spec, err)
+		}
+		return results
+	}
+	before := load(from)
+	after := load(to)
+	if db != nil {
+		db.Close()
+	}
+	log.Debugf("Comparing %d results of %s to %d results of %s", len(before), from, len(after), to)
+
+	diff := diffResults(before, after)
+	diff.From = from
+	diff.To = to
+
+	if format == OUTPUT_JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diff); err != nil {
+			log.Fatalf("Could not write diff: %s", err)
+		}
+	} else {
+		printDiff(os.Stdout, diff)
+	}
+
+	if diff.RegressionCount() > threshold {
+		log.Errorf("%d regressions exceed threshold %d", diff.RegressionCount(), threshold)
+		os.Exit(DIFF_EXIT_REGRESSION)
+	}
+}
+
+// coverageRank orders domain coverage from worst to best
+func coverageRank(coverage string) int {
+	switch coverage {
+	case COVERAGE_FULL:
+		return 2
+	case COVERAGE_PARTIAL:
+		return 1
+	}
+	return 0
+}
+
+func diffResults(before, after map[string]*DomainResult) *Diff {
+	diff := &Diff{
+		Regressions:  make([]*CoverageRegression, 0),
+		Improvements: make([]*CoverageRegression, 0),
+		LostROAs:     make([]*LostROA, 0),
+		NewASNs:      make([]*NewItems, 0),
+		NewTAs:       make([]*NewItems, 0),
+		Failed:       make([]*FailedMeasurement, 0),
+		Removed:      make([]string, 0),
+		Added:        make([]string, 0),
+	}
+
+	domains := make([]string, 0, len(after))
+	for domain := range after {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for domain := range before {
+		if _, ok := after[domain]; !ok {
+			diff.Removed = append(diff.Removed, domain)
+		}
+	}
+	sort.Strings(diff.Removed)
+
+	for _, domain := range domains {
+		b, ok := before[domain]
+		if !ok {
+			diff.Added = append(diff.Added, domain)
+			continue
+		}
+		a := after[domain]
+
+		// failed measurements carry no data to compare
+		if b.Stat.failed() || a.Stat.failed() {
+			failed := &FailedMeasurement{Domain: domain, Before: b.Stat.Status, After: a.Stat.Status, Reasons: a.Stat.Reasons}
+			if !a.Stat.failed() {
+				failed.Reasons = b.Stat.Reasons
+			}
+			if failed.Reasons == nil {
+				failed.Reasons = make([]string, 0)
+			}
+			diff.Failed = append(diff.Failed, failed)
+			continue
+		}
+
+		cb := domainCoverage(b.Stat)
+		ca := domainCoverage(a.Stat)
+		change := &CoverageRegression{Domain: domain, Before: cb, After: ca}
+		if coverageRank(ca) < coverageRank(cb) {
+			diff.Regressions = append(diff.Regressions, change)
+		} else if coverageRank(ca) > coverageRank(cb) {
+			diff.Improvements = append(diff.Improvements, change)
+		}
+
+		// address data is only there for detailed results
+		if b.Nameservers == nil || a.Nameservers == nil {
+			continue
+		}
+		hadROA := make(map[string]bool)
+		asns := make(map[string]bool)
+		tas := make(map[string]bool)
+		for _, ns := range b.Nameservers {
+			for _, addr := range ns.Addresses {
+				if addr.Roa == nil {
+					continue
+				}
+				hadROA[ns.Name+" "+addr.Ip] = true
+				for _, asn := range addr.Roa.Asn {
+					asns[asn] = true
+				}
+				for _, ta := range addr.Roa.Ta {
+					tas[ta] = true
+				}
+			}
+		}
+		newASNs := make([]string, 0)
+		newTAs := make([]string, 0)
+		for _, ns := range a.Nameservers {
+			for _, addr := range ns.Addresses {
+				if addr.Roa == nil {
+					if hadROA[ns.Name+" "+addr.Ip] {
+						diff.LostROAs = append(diff.LostROAs, &LostROA{Domain: domain, Nameserver: ns.Name, Ip: addr.Ip})
+					}
+					continue
+				}
+				for _, asn := range addr.Roa.Asn {
+					if !asns[asn] {
+						newASNs = append(newASNs, asn)
+					}
+				}
+				for _, ta := range addr.Roa.Ta {
+					if !tas[ta] {
+						newTAs = append(newTAs, ta)
+					}
+				}
+			}
+		}
+		if len(newASNs) > 0 {
+			items := unique(newASNs)
+			sort.Strings(items)
+			diff.NewASNs = append(diff.NewASNs, &NewItems{Domain: domain, Items: items})
+		}
+		if len(newTAs) > 0 {
+			items := unique(newTAs)
+			sort.Strings(items)
+			diff.NewTAs = append(diff.NewTAs, &NewItems{Domain: domain, Items: items})
+		}
+	}
+	return diff
+}
+
+func printDiff(w io.Writer, diff *Diff) {
+	fmt.Fprintf(w, "Diff %s -> %s\n", diff.From, diff.To)
+	fmt.Fprintf(w, "Regressions %d\n", len(diff.Regressions))
+	for _, r := range diff.Regressions {
+		fmt.Fprintf(w, "  %-30s %s -> %s\n", r.Domain, r.Before, r.After)
+	}
+	fmt.Fprintf(w, "Improvements %d\n", len(diff.Improvements))
+	for _, r := range diff.Improvements {
+		fmt.Fprintf(w, "  %-30s %s -> %s\n", r.Domain, r.Before, r.After)
+	}
+	fmt.Fprintf(w, "Addresses that lost ROAs %d\n", len(diff.LostROAs))
+	for _, l := range diff.LostROAs {
+		fmt.Fprintf(w, "  %-30s %-30s %s\n", l.Domain, l.Nameserver, l.Ip)
+	}
+	fmt.Fprintf(w, "New ASNs %d\n", len(diff.NewASNs))
+	for _, n := range diff.NewASNs {
+		fmt.Fprintf(w, "  %-30s %s\n", n.Domain, strings.Join(n.Items, " "))
+	}
+	fmt.Fprintf(w, "New TAs %d\n", len(diff.NewTAs))
+	for _, n := range diff.NewTAs {
+		fmt.Fprintf(w, "  %-30s %s\n", n.Domain, strings.Join(n.Items, " "))
+	}
+	if len(diff.Failed) > 0 {
+		fmt.Fprintf(w, "Failed measurements %d\n", len(diff.Failed))
+		for _, f := range diff.Failed {
+			fmt.Fprintf(w, "  %-30s %s -> %s (%s)\n", f.Domain, f.Before, f.After, strings.Join(f.Reasons, " "))
+		}
+	}
+	if len(diff.Removed) > 0 {
+		fmt.Fprintf(w, "Removed %s\n", strings.Join(diff.Removed, " "))
+	}
+	if len(diff.Added) > 0 {
+		fmt.Fprintf(w, "Added %s\n", strings.Join(diff.Added, " "))
+	}
+}
+
+// loadResultFile reads ndjson written by run, with or without --detail
+func loadResultFile(filename string) map[string]*DomainResult {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Could not open %s: %s", filename, err)
+	}
+	defer file.Close()
+
+	results := make(map[string]*DomainResult)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		result := &DomainResult{}
+		if err := json.Unmarshal(line, result); err != nil {
+			log.Fatalf("%s:%d: %s", filename, lineno, err)
+		}
+		if result.Stat == nil {
+			// not detailed, the line is the statistic itself
+			result.Stat = &RPKIstat{}
+			result.Nameservers = nil
+			if err := json.Unmarshal(line, result.Stat); err != nil {
+				log.Fatalf("%s:%d: %s", filename, lineno, err)
+			}
+		}
+		results[result.Stat.Domain] = result
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Could not read %s: %s", filename, err)
+	}
+	return results
+}
+
+// loadResultSet reads the results of a run (run id) or a day (YYYY-MM-DD) from the database
+func loadResultSet(db *Storage, spec string) (map[string]*DomainResult, error) {
+	var where string
+	var args []interface{}
+	if runID, err := strconv.ParseInt(spec, 10, 64); err == nil {
+		where = "R.RUN_ID = ?"
+		args = append(args, runID)
+	} else if day, err := time.Parse(DATE_FORMAT, spec); err == nil {
+		where = "R.TESTDATE >= ? AND R.TESTDATE < ?"
+		args = append(args, day, day.AddDate(0, 0, 1))
+	} else {
+		return nil, fmt.Errorf("%s is neither a file, a run id nor a date", spec)
+	}
+
+	rows, err := db.Query("SELECT R.ID, R.TESTDATE, R.TLD, R.NAMES, R.NAMES_ROA_FULL, R.NAMES_ROA_PARTIAL, R.IP4S, R.IP4S_ROAS, R.IP6S, R.IP6S_ROAS, R.TAS4, R.TAS6, R.AS4, R.AS6, R.STATUS, R.REASONS FROM RPKI R WHERE "+where+" ORDER BY R.TESTDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*DomainResult)
+	ids := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var reasons sql.NullString
+		stat := &RPKIstat{}
+		if err := rows.Scan(&id, &stat.Date, &stat.Domain, &stat.Names, &stat.NamesFull, &stat.NamesPartial, &stat.IPv4, &stat.IPv4roas, &stat.IPv6, &stat.IPv6roas, &stat.TAs4, &stat.TAs6, &stat.AS4, &stat.AS6, &stat.Status, &reasons); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stat.Reasons = splitList(reasons.String)
+		// later results of the same domain win, failed ones only over failed ones
+		if prev, ok := results[stat.Domain]; ok && stat.failed() && !prev.Stat.failed() {
+			continue
+		}
+		results[stat.Domain] = &DomainResult{Stat: stat}
+		ids[stat.Domain] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*DomainResult, len(ids))
+	for domain, id := range ids {
+		byID[id] = results[domain]
+	}
+	if err := loadDetails(db, where, args, byID); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// loadDetails reads the nameservers, addresses and ROAs of the RPKI rows
+// selected by where (on RPKI R) with one query per table and adds them to
+// the results in byID. Rows saved without details keep nil nameservers.
+func loadDetails(db *Storage, where string, args []interface{}, byID map[int64]*DomainResult) error {
+	rows, err := db.Query("SELECT N.ID, N.RPKI_ID, N.NAME, N.COVERAGE, N.GLUE_MISMATCH FROM RPKI_NS N JOIN RPKI R ON R.ID = N.RPKI_ID WHERE "+where+" ORDER BY N.NAME", args...)
+	if err != nil {
+		return err
+	}
+	nameservers := make(map[int64]*NameserverResult)
+	for rows.Next() {
+		var id, rpkiID int64
+		ns := &NameserverResult{Addresses: make([]*AddressResult, 0)}
+		if err := rows.Scan(&id, &rpkiID, &ns.Name, &ns.Coverage, &ns.GlueMismatch); err != nil {
+			rows.Close()
+			return err
+		}
+		// not the result chosen for the domain
+		result, ok := byID[rpkiID]
+		if !ok {
+			continue
+		}
+		result.Nameservers = append(result.Nameservers, ns)
+		nameservers[id] = ns
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT A.ID, A.RPKI_NS_ID, A.IP, A.PREFIX, A.PREFIX_SOURCE, A.ROA, A.ASNS, A.TAS, A.UNKNOWN, A.SOURCE FROM RPKI_ADDRESS A JOIN RPKI_NS N ON N.ID = A.RPKI_NS_ID JOIN RPKI R ON R.ID = N.RPKI_ID WHERE "+where+" ORDER BY A.IP", args...)
+	if err != nil {
+		return err
+	}
+	addresses := make(map[int64]*AddressResult)
+	for rows.Next() {
+		var id, nsID int64
+		var hasROA bool
+		var asns, tas string
+		addr := &AddressResult{}
+		if err := rows.Scan(&id, &nsID, &addr.Ip, &addr.Prefix, &addr.PrefixSource, &hasROA, &asns, &tas, &addr.Unknown, &addr.Source); err != nil {
+			rows.Close()
+			return err
+		}
+		ns, ok := nameservers[nsID]
+		if !ok {
+			continue
+		}
+		if hasROA {
+			addr.Roa = &ROA{Ip: addr.Ip, Prefix: addr.Prefix, PrefixSource: addr.PrefixSource, Asn: splitList(asns), Ta: splitList(tas), Vrps: make([]VRP, 0)}
+		}
+		ns.Addresses = append(ns.Addresses, addr)
+		addresses[id] = addr
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query("SELECT O.RPKI_ADDRESS_ID, O.PREFIX, O.MAXLENGTH, O.ASN, O.TA FROM RPKI_ROA O JOIN RPKI_ADDRESS A ON A.ID = O.RPKI_ADDRESS_ID JOIN RPKI_NS N ON N.ID = A.RPKI_NS_ID JOIN RPKI R ON R.ID = N.RPKI_ID WHERE "+where, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var addrID int64
+		var prefix string
+		var vrp VRP
+		if err := rows.Scan(&addrID, &prefix, &vrp.MaxLength, &vrp.ASN, &vrp.TA); err != nil {
+			return err
+		}
+		addr, ok := addresses[addrID]
+		if !ok || addr.Roa == nil {
+			continue
+		}
+		if vrp.Prefix, err = netip.ParsePrefix(prefix); err != nil {
+			return fmt.Errorf("invalid ROA prefix %s: %w", prefix, err)
+		}
+		addr.Roa.Vrps = append(addr.Roa.Vrps, vrp)
+	}
+	return rows.Err()
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
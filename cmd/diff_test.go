@@ -0,0 +1,75 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoadResultSetDetail(t *testing.T) {
+	db := testDB(t)
+	save := func(detail bool, results ...*DomainResult) *Run {
+		run := &Run{Started: time.Now().UTC(), Resolver: "127.0.0.1", ROASource: SourceMetadata{Name: "test"}, InputFile: "test.list", Version: "test"}
+		if err := startRun2db(db, run); err != nil {
+			t.Fatalf("startRun2db: %s", err)
+		}
+		if err := rpki2db(db, run, results, detail); err != nil {
+			t.Fatalf("rpki2db: %s", err)
+		}
+		return run
+	}
+
+	other := testResult()
+	other.Stat.Domain = "other"
+	other.Nameservers[0].Name = "ns.other."
+	run := save(true, testResult(), other)
+	// a later run must not add details to the first one
+	save(true, testResult())
+	plain := save(false, testResult())
+
+	results, err := loadResultSet(db, strconv.FormatInt(run.ID, 10))
+	if err != nil {
+		t.Fatalf("loadResultSet: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+	for domain, nsName := range map[string]string{"example": "ns1.example.net.", "other": "ns.other."} {
+		result := results[domain]
+		if result == nil || len(result.Nameservers) != 1 || result.Nameservers[0].Name != nsName {
+			t.Fatalf("%s: nameservers %+v, want %s", domain, result, nsName)
+		}
+		addrs := result.Nameservers[0].Addresses
+		if len(addrs) != 1 || addrs[0].Ip != "192.0.2.1" || addrs[0].Roa == nil {
+			t.Fatalf("%s: addresses %+v", domain, addrs)
+		}
+		want := testResult().Nameservers[0].Addresses[0].Roa.Vrps
+		if vrps := addrs[0].Roa.Vrps; len(vrps) != 1 || vrps[0] != want[0] {
+			t.Errorf("%s: VRPs %v, want %v", domain, vrps, want)
+		}
+	}
+
+	results, err = loadResultSet(db, strconv.FormatInt(plain.ID, 10))
+	if err != nil {
+		t.Fatalf("loadResultSet: %s", err)
+	}
+	if result := results["example"]; result == nil || result.Nameservers != nil {
+		t.Errorf("result saved without details has nameservers %+v", result)
+	}
+}
@@ -17,8 +17,12 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 
 	"github.com/apex/log"
@@ -29,15 +33,60 @@ import (
 
 )
 
-func getNS(domain string) (nslist []string) {
-	nslist = make([]string, 0)
-	msg := resolve(domain, dns.TypeNS)
-	if msg == nil {
-		log.Errorf("No name servers for %s", domain)
-		return
+// dnsLimit bounds the number of DNS queries in flight
+var dnsLimit limiter
+
+// use of several resolvers
+const RESOLVER_ROUNDROBIN string = "roundrobin"
+const RESOLVER_FAILOVER string = "failover"
+const RESOLVER_COMPARE string = "compare"
+
+// resolveQuery answers the queries of getNS, getIP4 and getIP6,
+// tests replace it to measure without a resolver
+var resolveQuery = resolve
+
+// resolverNext distributes queries over the resolvers in round robin mode
+var resolverNext atomic.Uint64
+
+// categories of failed lookups
+const (
+	ERROR_SERVFAIL = "servfail"
+	ERROR_TIMEOUT  = "timeout"
+	ERROR_NXDOMAIN = "nxdomain"
+	ERROR_DNS      = "dns"
+	ERROR_ROA      = "roa"
+)
+
+// DNSError is a failed DNS lookup
+type DNSError struct {
+	Name     string
+	Qtype    uint16
+	Category string
+	Err      error
+}
+
+func (e *DNSError) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Name, dns.TypeToString[e.Qtype], e.Category, e.Err)
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
+
+// errorCategory returns the category of a failed lookup
+func errorCategory(err error) string {
+	var dnsErr *DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.Category
 	}
-	if msg.Rcode != dns.RcodeSuccess {
-		log.Errorf("NS resolution error for %s: %s (Rcode %d)", domain, dns.RcodeToString[msg.Rcode], msg.Rcode)
+	return ERROR_DNS
+}
+
+func getNS(domain string) (nslist []string, err error) {
+	nslist = make([]string, 0)
+	msg, err := resolveQuery(domain, dns.TypeNS)
+	if err != nil {
+		log.Errorf("NS resolution error for %s: %s", domain, err)
 		return
 	}
 	for _,rr := range msg.Answer {
@@ -52,15 +101,11 @@ func getNS(domain string) (nslist []string) {
 	return
 }
 
-func getIP4(domain string) (ip4list []string) {
+func getIP4(domain string) (ip4list []string, err error) {
 	ip4list = make([]string, 0)
-	msg := resolve(domain, dns.TypeA)
-	if msg == nil {
-		log.Errorf("No IPv4 for %s", domain)
-		return
-	}
-	if msg.Rcode != dns.RcodeSuccess {
-		log.Errorf("IPv4 resolution error %s: %s (Rcode %d)", domain, dns.RcodeToString[msg.Rcode], msg.Rcode)
+	msg, err := resolveQuery(domain, dns.TypeA)
+	if err != nil {
+		log.Errorf("IPv4 resolution error %s: %s", domain, err)
 		return
 	}
 	for _,rr := range msg.Answer {
@@ -75,15 +120,11 @@ func getIP4(domain string) (ip4list []string) {
 	return
 }
 
-func getIP6(domain string) (ip6list []string) {
+func getIP6(domain string) (ip6list []string, err error) {
 	ip6list = make([]string, 0)
-	msg := resolve(domain, dns.TypeAAAA)
-	if msg == nil {
-		log.Errorf("No IPv6 for %s", domain)
-		return
-	}
-	if msg.Rcode != dns.RcodeSuccess {
-		log.Errorf("IPv6 resolution error %s: %s (Rcode %d)", domain, dns.RcodeToString[msg.Rcode], msg.Rcode)
+	msg, err := resolveQuery(domain, dns.TypeAAAA)
+	if err != nil {
+		log.Errorf("IPv6 resolution error %s: %s", domain, err)
 		return
 	}
 	for _,rr := range msg.Answer {
@@ -101,32 +142,83 @@ func getIP6(domain string) (ip6list []string) {
 
 
 
-// getResolvers will read the list of resolvers from /etc/resolv.conf
-func getResolver() string {
+// Resolver is a recursive resolver and the transport used to reach it
+type Resolver struct {
+	Net     string
+	Address string
+}
 
-	resolver := viper.GetString(RESOLVER)
+func (r Resolver) String() string {
+	return r.Net + "://" + r.Address
+}
 
-	ip := net.ParseIP(resolver)
+// parseResolver parses [udp://|tcp://]ip[:port], transport defaults to tcp and port to 53
+func parseResolver(spec string) (Resolver, error) {
+	resolver := Resolver{Net: "tcp"}
+	address := spec
+	if transport, rest, found := strings.Cut(spec, "://"); found {
+		if transport != "tcp" && transport != "udp" {
+			return resolver, fmt.Errorf("unknown transport %s of resolver %s", transport, spec)
+		}
+		resolver.Net = transport
+		address = rest
+	}
+
+	host, port := address, "53"
+	if net.ParseIP(address) == nil {
+		var err error
+		host, port, err = net.SplitHostPort(address)
+		if err != nil {
+			return resolver, fmt.Errorf("could not parse resolver %s: %s", spec, err)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return resolver, fmt.Errorf("could not parse port of resolver %s", spec)
+		}
+	}
+	ip := net.ParseIP(host)
 	if ip == nil {
-		log.Fatalf("Could not parse resolver ip: %s", resolver)
+		return resolver, fmt.Errorf("could not parse resolver ip: %s", spec)
 	}
+	resolver.Address = net.JoinHostPort(ip.String(), port)
+	return resolver, nil
+}
 
-	ipstr := ip.String()
-	if strings.ContainsAny(":", ipstr) {
-		// IPv6 address
-		ipstr = "[" + ipstr + "]:53"
-	} else {
-		// IPv4 address
-		ipstr = ipstr + ":53"
+// getResolvers returns the configured resolvers in the given order
+func getResolvers() ([]Resolver, error) {
+	specs := viper.GetStringSlice(RESOLVER)
+	if len(specs) == 0 {
+		return nil, errors.New("no resolver given")
 	}
-	return ipstr
+	resolvers := make([]Resolver, 0, len(specs))
+	for _, spec := range specs {
+		resolver, err := parseResolver(spec)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	return resolvers, nil
 }
 
-// resolv will send a query and save the result
-func resolve(domain string, qtype uint16) *dns.Msg {
+// resolverList describes the configured resolvers, it is saved with a run
+func resolverList() string {
+	return strings.Join(viper.GetStringSlice(RESOLVER), ",")
+}
+
+// resolv will send a query to the configured resolvers and return the answer
+func resolve(domain string, qtype uint16) (*dns.Msg, error) {
 	log.Debugf("CALLED resolve(%s, %d)", domain, qtype)
-	
-	server := getResolver()
+
+	resolvers, err := getResolvers()
+	if err != nil {
+		return nil, &DNSError{Name: domain, Qtype: qtype, Category: ERROR_DNS, Err: err}
+	}
+	return resolveWith(resolvers, domain, qtype)
+}
+
+// resolveWith sends a query to resolvers. A failed query is repeated with the
+// next resolver, in round robin mode every query starts with another resolver.
+func resolveWith(resolvers []Resolver, domain string, qtype uint16) (*dns.Msg, error) {
 
 	// Setting up query
 	query := new(dns.Msg)
@@ -135,47 +227,78 @@ func resolve(domain string, qtype uint16) *dns.Msg {
 	//query.SetEdns0(1232, false)
 	//query.IsEdns0().SetDo()
 
-	// Setting up resolver
-	client := new(dns.Client)
-	client.ReadTimeout = TIMEOUT * 1e9
-	client.Net = "tcp"
-
 	query.SetQuestion(dns.Fqdn(domain), qtype)
 
+	start := 0
+	if len(resolvers) > 1 && viper.GetString(RESOLVER_MODE) != RESOLVER_FAILOVER {
+		start = int(resolverNext.Add(1) % uint64(len(resolvers)))
+	}
+
 	// limit repeats
 	var repeat int = 0
+	var lastErr *DNSError
 	// query until we get an answer
 	for {
 
 		// limit repeats
 		repeat++
+		server := resolvers[(start+repeat-1)%len(resolvers)]
 		log.Debugf("%-30s: %d repeats reached (server %s, %s)", domain, repeat, server, dns.TypeToString[qtype])
 		if repeat > 10 {
 			log.Errorf("%-30s: 10 repeats reached (server %s)", domain, server)
-			break
+			return nil, lastErr
 		}
 
+		// Setting up resolver
+		client := new(dns.Client)
+		client.ReadTimeout = TIMEOUT * 1e9
+		client.Net = server.Net
+
 		// make the query and wait for answer
-		r, _, err := client.Exchange(query, server)
+		dnsLimit.acquire()
+		r, _, err := client.Exchange(query, server.Address)
+		if err == nil && r != nil && r.Truncated && server.Net == "udp" {
+			// answer too large for udp
+			client.Net = "tcp"
+			r, _, err = client.Exchange(query, server.Address)
+		}
+		dnsLimit.release()
 
 		// check for errors
 		if err != nil {
 			log.Errorf("%-30s: Error resolving %s (server %s)", domain, err, server)
+			category := ERROR_DNS
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				category = ERROR_TIMEOUT
+			}
+			lastErr = &DNSError{Name: domain, Qtype: qtype, Category: category, Err: err}
 			continue
 		}
 		if r == nil {
 			log.Errorf("%-30s: No answer (Server %s)", domain, server)
+			lastErr = &DNSError{Name: domain, Qtype: qtype, Category: ERROR_DNS, Err: errors.New("no answer")}
+			continue
+		}
+		if r.Rcode == dns.RcodeServerFailure && repeat < len(resolvers) {
+			// another resolver might do better
+			log.Warnf("%-30s: SERVFAIL (Server %s), trying next resolver", domain, server)
+			lastErr = &DNSError{Name: domain, Qtype: qtype, Category: ERROR_SERVFAIL, Err: errors.New("rcode SERVFAIL")}
 			continue
 		}
 		if r.Rcode != dns.RcodeSuccess {
 			log.Errorf("%-30s: %s (Rcode %d, Server %s)", domain, dns.RcodeToString[r.Rcode], r.Rcode, server)
-			break
+			category := ERROR_DNS
+			switch r.Rcode {
+			case dns.RcodeServerFailure:
+				category = ERROR_SERVFAIL
+			case dns.RcodeNameError:
+				category = ERROR_NXDOMAIN
+			}
+			return nil, &DNSError{Name: domain, Qtype: qtype, Category: category, Err: fmt.Errorf("rcode %s", dns.RcodeToString[r.Rcode])}
 		}
 
 		// we got an answer
-		return r
+		return r, nil
 	}
-
-	return nil
 }
-
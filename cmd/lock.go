@@ -0,0 +1,37 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errLocked is returned if another process holds the run lock
+var errLocked = errors.New("another run holds the lock")
+
+// RunLock prevents overlapping runs over domain lists
+type RunLock struct {
+	filename string
+	file     *os.File
+}
+
+// defaultLockFile is used if no lock file is configured
+func defaultLockFile() string {
+	return filepath.Join(os.TempDir(), "rpkistats.lock")
+}
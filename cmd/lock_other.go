@@ -0,0 +1,43 @@
+//go:build !unix
+
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// lockRun creates filename exclusively. The file is left behind if the
+// process dies and has to be removed by hand.
+func lockRun(filename string) (*RunLock, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return &RunLock{filename: filename, file: file}, nil
+}
+
+func (l *RunLock) unlock() {
+	l.file.Close()
+	os.Remove(l.filename)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// migrations are named <version>_<name>.sql and applied in order of version,
+// every dialect has its own directory with the same versions
+//
+//go:embed migrations/*/*.sql
+var migrationFS embed.FS
+
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// AppliedMigration is a row of the SCHEMA_VERSION table
+type AppliedMigration struct {
+	Version int
+	Name    string
+	Applied time.Time
+}
+
+func loadMigrations(dialect Dialect) []migration {
+	dir := path.Join("migrations", dialect.Name())
+	files, err := migrationFS.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Could not read migrations: %s", err)
+	}
+	migrations := make([]migration, 0)
+	for _, file := range files {
+		base := strings.TrimSuffix(file.Name(), ".sql")
+		version, name, found := strings.Cut(base, "_")
+		if !found {
+			log.Fatalf("Migration %s is not named <version>_<name>.sql", file.Name())
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			log.Fatalf("Migration %s has no valid version: %s", file.Name(), err)
+		}
+		data, err := migrationFS.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			log.Fatalf("Could not read migration %s: %s", file.Name(), err)
+		}
+		migrations = append(migrations, migration{version: v, name: name, statements: splitStatements(string(data))})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations
+}
+
+// latestSchemaVersion is the version the code writes to
+func latestSchemaVersion(dialect Dialect) int {
+	migrations := loadMigrations(dialect)
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// splitStatements splits a migration at ";" and drops "--" comments
+func splitStatements(script string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	statements := make([]string, 0)
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		statement = strings.TrimSpace(statement)
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func ensureSchemaTable(db *Storage) error {
+	_, err := db.Exec(db.dialect.SchemaTable())
+	return err
+}
+
+// tableExists tells if the database has a table named table
+func tableExists(db *Storage, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(db.dialect.TableExists(), table).Scan(&count)
+	return count > 0, err
+}
+
+// appliedMigrations returns the migrations applied to db in order,
+// it does not write to db and a missing SCHEMA_VERSION table means none
+func appliedMigrations(db *Storage) ([]AppliedMigration, error) {
+	applied := make([]AppliedMigration, 0)
+	exists, err := tableExists(db, "SCHEMA_VERSION")
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := db.Query("SELECT VERSION, NAME, APPLIED FROM SCHEMA_VERSION ORDER BY VERSION")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Applied); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// legacyRPKI is true if db has an RPKI table without ID column,
+// it was written before rpkistats had migrations
+func legacyRPKI(db *Storage) (bool, error) {
+	exists, err := tableExists(db, "RPKI")
+	if err != nil || !exists {
+		return false, err
+	}
+	rows, err := db.Query("SELECT * FROM RPKI WHERE 1 = 0")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	for _, column := range columns {
+		if strings.EqualFold(column, "ID") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// schemaVersion returns the highest migration applied to db
+func schemaVersion(db *Storage) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// checkSchema refuses to work with an outdated database schema
+func checkSchema(db *Storage) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %s", err)
+	}
+	latest := latestSchemaVersion(db.dialect)
+	if version < latest {
+		return fmt.Errorf("database schema version %d is outdated (need %d), run \"rpkistats db migrate\"", version, latest)
+	}
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than this rpkistats supports (%d)", version, latest)
+	}
+	log.Debugf("Database schema version %d", version)
+	return nil
+}
+
+// migrateDB applies all missing migrations. An RPKI table written before
+// rpkistats had migrations gets an ID column before the first migration.
+func migrateDB(db *Storage) error {
+	if err := ensureSchemaTable(db); err != nil {
+		return fmt.Errorf("could not create SCHEMA_VERSION: %s", err)
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %s", err)
+	}
+	var adopt []string
+	if version == 0 {
+		legacy, err := legacyRPKI(db)
+		if err != nil {
+			return fmt.Errorf("could not read RPKI table: %s", err)
+		}
+		if legacy {
+			adopt = db.dialect.AddRPKIID()
+			if adopt == nil {
+				return fmt.Errorf("the RPKI table has no ID column and %s cannot add one, copy the data to a new database", db.dialect.Name())
+			}
+			log.Infof("Adding ID column to existing RPKI table")
+		}
+	}
+	for _, m := range loadMigrations(db.dialect) {
+		if m.version <= version {
+			continue
+		}
+		if adopt != nil {
+			m.statements = append(adopt, m.statements...)
+			adopt = nil
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the statements of m and records m in SCHEMA_VERSION
+// in one transaction. MySQL commits DDL implicitly, there a failed migration
+// can be left half applied.
+func applyMigration(db *Storage, m migration) error {
+	log.Infof("Applying migration %d %s", m.version, m.name)
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start DB transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, statement := range m.statements {
+		log.Debugf("SQL: %s", statement)
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("migration %d %s failed: %s", m.version, m.name, err)
+		}
+	}
+	_, err = tx.Exec("INSERT INTO SCHEMA_VERSION(VERSION, NAME, APPLIED) VALUES (?, ?, ?)", m.version, m.name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("could not record migration %d %s: %s", m.version, m.name, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit migration %d %s: %s", m.version, m.name, err)
+	}
+	return nil
+}
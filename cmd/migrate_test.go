@@ -0,0 +1,107 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateDB(t *testing.T) {
+	db := testStorage(t)
+	for i := 0; i < 2; i++ {
+		if err := migrateDB(db); err != nil {
+			t.Fatalf("migrateDB: %s", err)
+		}
+		version, err := schemaVersion(db)
+		if err != nil {
+			t.Fatalf("schemaVersion: %s", err)
+		}
+		if latest := latestSchemaVersion(db.dialect); version != latest {
+			t.Errorf("schema version %d, want %d", version, latest)
+		}
+	}
+	if err := checkSchema(db); err != nil {
+		t.Errorf("checkSchema: %s", err)
+	}
+}
+
+// reading the schema version must not create SCHEMA_VERSION
+func TestAppliedMigrationsReadOnly(t *testing.T) {
+	db := testStorage(t)
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatalf("appliedMigrations: %s", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("%d migrations applied to an empty database", len(applied))
+	}
+	if err := checkSchema(db); err == nil {
+		t.Errorf("checkSchema accepted an empty database")
+	}
+	exists, err := tableExists(db, "SCHEMA_VERSION")
+	if err != nil {
+		t.Fatalf("tableExists: %s", err)
+	}
+	if exists {
+		t.Errorf("SCHEMA_VERSION was created")
+	}
+}
+
+// a failed statement rolls back the whole migration
+func TestApplyMigrationRollback(t *testing.T) {
+	db := testStorage(t)
+	if err := ensureSchemaTable(db); err != nil {
+		t.Fatalf("ensureSchemaTable: %s", err)
+	}
+	m := migration{version: 1, name: "broken", statements: []string{"CREATE TABLE HALF (A INT)", "ALTER TABLE MISSING ADD B INT"}}
+	if err := applyMigration(db, m); err == nil {
+		t.Fatalf("applyMigration of a broken migration returned no error")
+	}
+	if exists, _ := tableExists(db, "HALF"); exists {
+		t.Errorf("first statement of a failed migration was not rolled back")
+	}
+	if version, _ := schemaVersion(db); version != 0 {
+		t.Errorf("failed migration recorded as version %d", version)
+	}
+}
+
+func TestLegacyRPKI(t *testing.T) {
+	db := testStorage(t)
+	if legacy, err := legacyRPKI(db); err != nil || legacy {
+		t.Errorf("legacyRPKI of an empty database = %t, %v", legacy, err)
+	}
+	if _, err := db.Exec(legacyRPKITable); err != nil {
+		t.Fatalf("create legacy table: %s", err)
+	}
+	if legacy, err := legacyRPKI(db); err != nil || !legacy {
+		t.Errorf("legacyRPKI of a legacy table = %t, %v", legacy, err)
+	}
+	// SQLite cannot add the primary key
+	err := migrateDB(db)
+	if err == nil || !strings.Contains(err.Error(), "no ID column") {
+		t.Errorf("migrateDB = %v, want refusal", err)
+	}
+	if version, _ := schemaVersion(db); version != 0 {
+		t.Errorf("legacy database migrated to version %d", version)
+	}
+
+	migrated := testDB(t)
+	if legacy, err := legacyRPKI(migrated); err != nil || legacy {
+		t.Errorf("legacyRPKI of a migrated database = %t, %v", legacy, err)
+	}
+}
@@ -0,0 +1,4 @@
+-- failed ROA source lookups
+ALTER TABLE RPKI
+	ADD COLUMN IP4S_UNKNOWN INT NOT NULL DEFAULT 0,
+	ADD COLUMN IP6S_UNKNOWN INT NOT NULL DEFAULT 0;
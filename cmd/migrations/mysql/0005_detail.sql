@@ -0,0 +1,35 @@
+-- per nameserver and per address details (run --detail)
+CREATE TABLE IF NOT EXISTS RPKI_NS (
+	ID BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	RPKI_ID BIGINT NOT NULL,
+	NAME VARCHAR(255) NOT NULL,
+	COVERAGE VARCHAR(16) NOT NULL,
+	FOREIGN KEY (RPKI_ID) REFERENCES RPKI (ID) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS RPKI_ADDRESS (
+	ID BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	RPKI_NS_ID BIGINT NOT NULL,
+	IP VARCHAR(45) NOT NULL,
+	PREFIX VARCHAR(49) NOT NULL,
+	PREFIX_SOURCE VARCHAR(16) NOT NULL,
+	ROA BOOLEAN NOT NULL,
+	ASNS TEXT NOT NULL,
+	TAS TEXT NOT NULL,
+	MAXLEN_INVALID BOOLEAN NOT NULL,
+	MAXLEN_PERMISSIVE BOOLEAN NOT NULL,
+	ORIGIN BIGINT NULL,
+	VALIDITY VARCHAR(16) NULL,
+	VALIDITY_REASON VARCHAR(16) NULL,
+	UNKNOWN BOOLEAN NOT NULL,
+	FOREIGN KEY (RPKI_NS_ID) REFERENCES RPKI_NS (ID) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS RPKI_ROA (
+	RPKI_ADDRESS_ID BIGINT NOT NULL,
+	PREFIX VARCHAR(49) NOT NULL,
+	MAXLENGTH INT NOT NULL,
+	ASN BIGINT NOT NULL,
+	TA VARCHAR(64) NOT NULL,
+	FOREIGN KEY (RPKI_ADDRESS_ID) REFERENCES RPKI_ADDRESS (ID) ON DELETE CASCADE
+);
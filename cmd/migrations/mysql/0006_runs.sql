@@ -0,0 +1,20 @@
+-- metadata of every run, results reference the run they belong to
+CREATE TABLE IF NOT EXISTS RUNS (
+	ID BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	STARTED DATETIME NOT NULL,
+	FINISHED DATETIME NULL,
+	RESOLVER VARCHAR(255) NOT NULL,
+	ROA_SOURCE VARCHAR(255) NOT NULL,
+	ROA_SERIAL BIGINT NULL,
+	ROA_UPDATED DATETIME NULL,
+	INPUT_FILE VARCHAR(1024) NOT NULL,
+	INPUT_HASH VARCHAR(64) NOT NULL,
+	VERSION VARCHAR(64) NOT NULL,
+	DOMAINS INT NOT NULL DEFAULT 0,
+	DNS_ERRORS INT NOT NULL DEFAULT 0,
+	ROA_ERRORS INT NOT NULL DEFAULT 0
+);
+
+ALTER TABLE RPKI
+	ADD COLUMN RUN_ID BIGINT NULL,
+	ADD CONSTRAINT RPKI_RUN FOREIGN KEY (RUN_ID) REFERENCES RUNS (ID);
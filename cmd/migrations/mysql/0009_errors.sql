@@ -0,0 +1,9 @@
+-- failed lookups per category
+ALTER TABLE RPKI
+	ADD COLUMN ERRORS_SERVFAIL INT NOT NULL DEFAULT 0,
+	ADD COLUMN ERRORS_TIMEOUT INT NOT NULL DEFAULT 0,
+	ADD COLUMN ERRORS_NXDOMAIN INT NOT NULL DEFAULT 0,
+	ADD COLUMN ERRORS_DNS INT NOT NULL DEFAULT 0,
+	ADD COLUMN ERRORS_ROA INT NOT NULL DEFAULT 0;
+
+ALTER TABLE RPKI_NS ADD COLUMN ERROR VARCHAR(16) NULL;
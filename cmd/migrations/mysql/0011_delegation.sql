@@ -0,0 +1,7 @@
+-- NS set of the statistic and comparison with the delegation in the parent
+ALTER TABLE RPKI
+	ADD COLUMN NS_SET VARCHAR(8) NOT NULL DEFAULT 'child',
+	ADD COLUMN PARENT_ONLY INT NOT NULL DEFAULT 0,
+	ADD COLUMN CHILD_ONLY INT NOT NULL DEFAULT 0,
+	ADD COLUMN PARENT_COVERAGE VARCHAR(8) NULL,
+	ADD COLUMN CHILD_COVERAGE VARCHAR(8) NULL;
@@ -0,0 +1,14 @@
+-- answers of the nameserver addresses to SOA queries (run --probe), NULL if not probed
+ALTER TABLE RPKI
+	ADD COLUMN IP4S_SERVING INT NULL,
+	ADD COLUMN IP4S_SERVING_ROAS INT NULL,
+	ADD COLUMN IP6S_SERVING INT NULL,
+	ADD COLUMN IP6S_SERVING_ROAS INT NULL,
+	ADD COLUMN SOA_SERIALS INT NULL;
+
+ALTER TABLE RPKI_ADDRESS
+	ADD COLUMN ANSWERED BOOLEAN NULL,
+	ADD COLUMN AUTHORITATIVE BOOLEAN NULL,
+	ADD COLUMN SERIAL BIGINT NULL,
+	ADD COLUMN RTT DOUBLE PRECISION NULL,
+	ADD COLUMN PROBE_ERROR VARCHAR(16) NULL;
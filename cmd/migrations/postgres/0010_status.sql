@@ -0,0 +1,6 @@
+-- status of a measurement, failed rows carry no data
+ALTER TABLE RPKI
+	ADD COLUMN STATUS VARCHAR(16) NOT NULL DEFAULT 'ok',
+	ADD COLUMN REASONS VARCHAR(255) NULL;
+
+UPDATE RPKI SET STATUS = 'failed', REASONS = 'no_nameservers' WHERE NAMES = 0;
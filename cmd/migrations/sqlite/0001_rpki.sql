@@ -0,0 +1,21 @@
+-- RPKI statistics per domain and test date
+CREATE TABLE IF NOT EXISTS RPKI (
+	ID INTEGER PRIMARY KEY AUTOINCREMENT,
+	TESTDATE DATETIME NOT NULL,
+	TLD VARCHAR(255) NOT NULL,
+	NAMES INT NOT NULL DEFAULT 0,
+	NAMES_ROA_FULL INT NOT NULL DEFAULT 0,
+	NAMES_ROA_PARTIAL INT NOT NULL DEFAULT 0,
+	IP4S INT NOT NULL DEFAULT 0,
+	IP4S_ROAS INT NOT NULL DEFAULT 0,
+	IP6S INT NOT NULL DEFAULT 0,
+	IP6S_ROAS INT NOT NULL DEFAULT 0,
+	TAS4 INT NOT NULL DEFAULT 0,
+	TAS6 INT NOT NULL DEFAULT 0,
+	AS4 INT NOT NULL DEFAULT 0,
+	AS6 INT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX RPKI_TESTDATE ON RPKI (TESTDATE);
+
+CREATE INDEX RPKI_TLD ON RPKI (TLD);
@@ -0,0 +1,10 @@
+-- route origin validation of nameserver addresses
+-- SQLite adds only one column per ALTER TABLE
+ALTER TABLE RPKI ADD COLUMN IP4S_VALID INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP4S_INVALID_AS INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP4S_INVALID_LENGTH INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP4S_NOT_FOUND INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_VALID INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_INVALID_AS INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_INVALID_LENGTH INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_NOT_FOUND INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- failed ROA source lookups
+ALTER TABLE RPKI ADD COLUMN IP4S_UNKNOWN INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_UNKNOWN INT NOT NULL DEFAULT 0;
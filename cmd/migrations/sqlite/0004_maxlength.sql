@@ -0,0 +1,5 @@
+-- max length of covering ROAs
+ALTER TABLE RPKI ADD COLUMN IP4S_MAXLEN_INVALID INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_MAXLEN_INVALID INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP4S_MAXLEN_PERMISSIVE INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN IP6S_MAXLEN_PERMISSIVE INT NOT NULL DEFAULT 0;
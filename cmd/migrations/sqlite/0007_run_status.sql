@@ -0,0 +1,6 @@
+-- outcome of a run, runs still running when the process died are failed
+ALTER TABLE RUNS ADD COLUMN STATUS VARCHAR(16) NOT NULL DEFAULT 'running';
+ALTER TABLE RUNS ADD COLUMN ERROR TEXT NULL;
+
+UPDATE RUNS SET STATUS = 'success' WHERE FINISHED IS NOT NULL;
+UPDATE RUNS SET STATUS = 'failed', ERROR = 'interrupted' WHERE FINISHED IS NULL;
@@ -0,0 +1,4 @@
+-- number of domains of the input file saved so far, resumed runs continue after them
+ALTER TABLE RUNS ADD COLUMN DONE INT NOT NULL DEFAULT 0;
+
+UPDATE RUNS SET DONE = DOMAINS;
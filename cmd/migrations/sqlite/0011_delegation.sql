@@ -0,0 +1,6 @@
+-- NS set of the statistic and comparison with the delegation in the parent
+ALTER TABLE RPKI ADD COLUMN NS_SET VARCHAR(8) NOT NULL DEFAULT 'child';
+ALTER TABLE RPKI ADD COLUMN PARENT_ONLY INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN CHILD_ONLY INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN PARENT_COVERAGE VARCHAR(8) NULL;
+ALTER TABLE RPKI ADD COLUMN CHILD_COVERAGE VARCHAR(8) NULL;
@@ -0,0 +1,6 @@
+-- glue of the parent compared with the resolved addresses
+ALTER TABLE RPKI ADD COLUMN GLUE_NAMES INT NOT NULL DEFAULT 0;
+ALTER TABLE RPKI ADD COLUMN GLUE_MISMATCH INT NOT NULL DEFAULT 0;
+
+ALTER TABLE RPKI_NS ADD COLUMN GLUE_MISMATCH BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE RPKI_ADDRESS ADD COLUMN SOURCE VARCHAR(8) NOT NULL DEFAULT 'resolved';
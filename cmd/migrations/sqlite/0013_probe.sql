@@ -0,0 +1,12 @@
+-- answers of the nameserver addresses to SOA queries (run --probe), NULL if not probed
+ALTER TABLE RPKI ADD COLUMN IP4S_SERVING INT NULL;
+ALTER TABLE RPKI ADD COLUMN IP4S_SERVING_ROAS INT NULL;
+ALTER TABLE RPKI ADD COLUMN IP6S_SERVING INT NULL;
+ALTER TABLE RPKI ADD COLUMN IP6S_SERVING_ROAS INT NULL;
+ALTER TABLE RPKI ADD COLUMN SOA_SERIALS INT NULL;
+
+ALTER TABLE RPKI_ADDRESS ADD COLUMN ANSWERED BOOLEAN NULL;
+ALTER TABLE RPKI_ADDRESS ADD COLUMN AUTHORITATIVE BOOLEAN NULL;
+ALTER TABLE RPKI_ADDRESS ADD COLUMN SERIAL BIGINT NULL;
+ALTER TABLE RPKI_ADDRESS ADD COLUMN RTT DOUBLE PRECISION NULL;
+ALTER TABLE RPKI_ADDRESS ADD COLUMN PROBE_ERROR VARCHAR(16) NULL;
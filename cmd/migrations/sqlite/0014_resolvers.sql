@@ -0,0 +1,2 @@
+-- names the resolvers answered differently (run --resolvermode compare), NULL if not compared
+ALTER TABLE RPKI ADD COLUMN RESOLVER_DIFFERENCES INT NULL;
@@ -0,0 +1,179 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/apex/log"
+)
+
+// OriginTable maps announced prefixes to their origin AS numbers
+type OriginTable struct {
+	prefixes map[netip.Prefix][]uint32
+}
+
+// origins is the prefix to origin table given on the command line (if any),
+// serve mode replaces it while requests are answered
+var origins atomic.Pointer[OriginTable]
+
+// loadOriginTable reads a prefix to origin table. Supported formats are
+// CAIDA pfx2as files (prefix, length and origin separated by white space)
+// and the one line per route output of "bgpdump -m" for MRT RIB dumps.
+func loadOriginTable(filename string) (*OriginTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading prefix to origin file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	table := &OriginTable{prefixes: make(map[netip.Prefix][]uint32)}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+
+		// jump over empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var prefix netip.Prefix
+		var asns []uint32
+		if strings.Contains(line, "|") {
+			prefix, asns, err = parseBgpdumpLine(line)
+		} else {
+			prefix, asns, err = parsePfx2asLine(line)
+		}
+		if err != nil {
+			log.Warnf("%s:%d: %s", filename, lineno, err)
+			continue
+		}
+		table.add(prefix, asns)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading prefix to origin file %s: %w", filename, err)
+	}
+
+	log.Debugf("Loaded %d announced prefixes from %s", len(table.prefixes), filename)
+	return table, nil
+}
+
+func (t *OriginTable) add(prefix netip.Prefix, asns []uint32) {
+	prefix = prefix.Masked()
+	known := t.prefixes[prefix]
+	for _, asn := range asns {
+		found := false
+		for _, k := range known {
+			if k == asn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			known = append(known, asn)
+		}
+	}
+	t.prefixes[prefix] = known
+}
+
+// lookup returns the most specific announced prefix covering ip and its origins
+func (t *OriginTable) lookup(ip netip.Addr) (netip.Prefix, []uint32, bool) {
+	if t == nil {
+		return netip.Prefix{}, nil, false
+	}
+	ip = ip.Unmap()
+	for bits := ip.BitLen(); bits >= 0; bits-- {
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if asns, ok := t.prefixes[prefix]; ok {
+			return prefix, asns, true
+		}
+	}
+	return netip.Prefix{}, nil, false
+}
+
+// parsePfx2asLine parses "1.0.0.0	24	13335" where the origin might
+// be a multi origin "13335_4826" or an AS set "13335,4826"
+func parsePfx2asLine(line string) (prefix netip.Prefix, asns []uint32, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 2 && strings.Contains(fields[0], "/") {
+		// "1.0.0.0/24 13335"
+		prefix, err = netip.ParsePrefix(fields[0])
+		if err != nil {
+			return
+		}
+		asns, err = parseOrigins(fields[1])
+		return
+	}
+	if len(fields) != 3 {
+		err = fmt.Errorf("expected 3 fields, found %d", len(fields))
+		return
+	}
+	prefix, err = netip.ParsePrefix(fields[0] + "/" + fields[1])
+	if err != nil {
+		return
+	}
+	asns, err = parseOrigins(fields[2])
+	return
+}
+
+// parseBgpdumpLine parses "TABLE_DUMP2|1714521600|B|192.0.2.1|64496|1.0.0.0/24|64496 13335|IGP|..."
+func parseBgpdumpLine(line string) (prefix netip.Prefix, asns []uint32, err error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 7 {
+		err = fmt.Errorf("expected at least 7 fields, found %d", len(fields))
+		return
+	}
+	prefix, err = netip.ParsePrefix(fields[5])
+	if err != nil {
+		return
+	}
+	path := strings.Fields(fields[6])
+	if len(path) == 0 {
+		err = fmt.Errorf("empty AS path for %s", prefix)
+		return
+	}
+	asns, err = parseOrigins(path[len(path)-1])
+	return
+}
+
+func parseOrigins(origin string) (asns []uint32, err error) {
+	origin = strings.Trim(origin, "{}")
+	for _, as := range strings.FieldsFunc(origin, func(r rune) bool { return r == '_' || r == ',' }) {
+		var asn uint32
+		asn, err = parseASN(as)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse origin %s: %s", origin, err)
+		}
+		asns = append(asns, asn)
+	}
+	if len(asns) == 0 {
+		err = fmt.Errorf("no origin found")
+	}
+	return
+}
@@ -0,0 +1,347 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/spf13/viper"
+)
+
+// writeOutput writes results in format to stdout or the file given on the command line
+func writeOutput(format string, results []*DomainResult) {
+	var out io.Writer = os.Stdout
+	if filename := viper.GetString(OUTPUT_FILE); filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			log.Fatalf("Could not create output file %s: %s", filename, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := writeResults(out, format, results, viper.GetBool(DETAIL)); err != nil {
+		log.Fatalf("Could not write output: %s", err)
+	}
+}
+
+// writeResults writes results in one of the output formats.
+// With detail the nameservers and addresses are included.
+func writeResults(w io.Writer, format string, results []*DomainResult, detail bool) error {
+	switch format {
+	case OUTPUT_TEXT:
+		for _, result := range results {
+			printStat(w, result.Stat)
+			if detail {
+				printDetail(w, result)
+			}
+		}
+	case OUTPUT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if detail {
+			return encoder.Encode(results)
+		}
+		return encoder.Encode(statsOf(results))
+	case OUTPUT_NDJSON:
+		encoder := json.NewEncoder(w)
+		for _, result := range results {
+			var err error
+			if detail {
+				err = encoder.Encode(result)
+			} else {
+				err = encoder.Encode(result.Stat)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case OUTPUT_CSV:
+		writer := csv.NewWriter(w)
+		if detail {
+			writer.Write(append(statCSVHeader(), detailCSVHeader()...))
+			for _, result := range results {
+				records := detailCSVRecords(result)
+				if len(records) == 0 {
+					// a domain without nameservers keeps its row
+					records = append(records, make([]string, len(detailCSVHeader())))
+				}
+				for _, record := range records {
+					writer.Write(append(statCSVRecord(result.Stat), record...))
+				}
+			}
+		} else {
+			writer.Write(statCSVHeader())
+			for _, result := range results {
+				writer.Write(statCSVRecord(result.Stat))
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown output format %s", format)
+	}
+	return nil
+}
+
+func statsOf(results []*DomainResult) []*RPKIstat {
+	stats := make([]*RPKIstat, 0, len(results))
+	for _, result := range results {
+		stats = append(stats, result.Stat)
+	}
+	return stats
+}
+
+func statCSVHeader() []string {
+	return []string{"domain", "date", "names", "names_full", "names_partial",
+		"ipv4", "ipv4_roas", "tas4", "as4", "ipv6", "ipv6_roas", "tas6", "as6",
+		"ipv4_valid", "ipv4_invalid_as", "ipv4_invalid_length", "ipv4_not_found",
+		"ipv6_valid", "ipv6_invalid_as", "ipv6_invalid_length", "ipv6_not_found",
+		"ipv4_maxlength_invalid", "ipv6_maxlength_invalid", "ipv4_maxlength_permissive", "ipv6_maxlength_permissive",
+		"ipv4_unknown", "ipv6_unknown",
+		"errors_servfail", "errors_timeout", "errors_nxdomain", "errors_dns", "errors_roa",
+		"status", "reasons", "ns_set", "parent_only", "child_only", "parent_coverage", "child_coverage",
+		"glue_names", "glue_mismatch",
+		"ipv4_serving", "ipv4_serving_roas", "ipv6_serving", "ipv6_serving_roas", "soa_serials",
+		"resolver_differences"}
+}
+
+func statCSVRecord(stat *RPKIstat) []string {
+	record := []string{stat.Domain, stat.Date.Format(time.RFC3339)}
+	for _, n := range []int{stat.Names, stat.NamesFull, stat.NamesPartial,
+		stat.IPv4, stat.IPv4roas, stat.TAs4, stat.AS4, stat.IPv6, stat.IPv6roas, stat.TAs6, stat.AS6,
+		stat.IPv4valid, stat.IPv4invalidAS, stat.IPv4invalidLength, stat.IPv4notFound,
+		stat.IPv6valid, stat.IPv6invalidAS, stat.IPv6invalidLength, stat.IPv6notFound,
+		stat.IPv4maxLengthInvalid, stat.IPv6maxLengthInvalid, stat.IPv4maxLengthPermissive, stat.IPv6maxLengthPermissive,
+		stat.IPv4unknown, stat.IPv6unknown,
+		stat.ErrServfail, stat.ErrTimeout, stat.ErrNxdomain, stat.ErrDNS, stat.ErrROA} {
+		record = append(record, strconv.Itoa(n))
+	}
+	record = append(record, stat.Status, strings.Join(stat.Reasons, " "), stat.NSSet,
+		strconv.Itoa(stat.ParentOnly), strconv.Itoa(stat.ChildOnly), stat.ParentCoverage, stat.ChildCoverage,
+		strconv.Itoa(stat.GlueNames), strconv.Itoa(stat.GlueMismatch))
+	// not probed is no data, not zero
+	for _, n := range []int{stat.IPv4serving, stat.IPv4servingROAs, stat.IPv6serving, stat.IPv6servingROAs, stat.Serials} {
+		if stat.Probed {
+			record = append(record, strconv.Itoa(n))
+		} else {
+			record = append(record, "")
+		}
+	}
+	if stat.ResolversCompared {
+		record = append(record, strconv.Itoa(stat.ResolverDifferences))
+	} else {
+		record = append(record, "")
+	}
+	return record
+}
+
+func detailCSVHeader() []string {
+	return []string{"nameserver", "coverage", "ip", "prefix", "prefix_source", "roa", "asns", "tas",
+		"maxlength_invalid", "maxlength_permissive", "origin", "validity", "validity_reason", "unknown", "source",
+		"answered", "authoritative", "serial", "rtt_ms", "probe_error"}
+}
+
+// detailCSVRecords returns one record per nameserver address,
+// a nameserver without addresses has a record with empty address columns
+func detailCSVRecords(result *DomainResult) (records [][]string) {
+	records = make([][]string, 0)
+	for _, ns := range result.Nameservers {
+		if len(ns.Addresses) == 0 {
+			record := make([]string, len(detailCSVHeader()))
+			record[0], record[1] = ns.Name, ns.Coverage
+			records = append(records, record)
+		}
+		for _, addr := range ns.Addresses {
+			record := []string{ns.Name, ns.Coverage, addr.Ip, addr.Prefix, addr.PrefixSource}
+			if addr.Roa != nil {
+				record = append(record, "true", strings.Join(addr.Roa.Asn, " "), strings.Join(addr.Roa.Ta, " "),
+					strconv.FormatBool(addr.Roa.MaxLengthInvalid), strconv.FormatBool(addr.Roa.MaxLengthPermissive))
+			} else {
+				record = append(record, "false", "", "", "false", "false")
+			}
+			if addr.Validity != nil {
+				record = append(record, fmt.Sprintf("AS%d", addr.Validity.Origin), addr.Validity.State, addr.Validity.Reason)
+			} else {
+				record = append(record, "", "", "")
+			}
+			record = append(record, strconv.FormatBool(addr.Unknown), addr.Source)
+			if addr.Probe != nil {
+				record = append(record, strconv.FormatBool(addr.Probe.Answered), strconv.FormatBool(addr.Probe.Authoritative),
+					strconv.FormatUint(uint64(addr.Probe.Serial), 10), strconv.FormatFloat(addr.Probe.RTT, 'f', 1, 64), addr.Probe.Error)
+			} else {
+				record = append(record, "", "", "", "", "")
+			}
+			records = append(records, record)
+		}
+	}
+	return
+}
+
+func printStat(w io.Writer, rpkistat *RPKIstat) {
+	fmt.Fprintf(w, "Domain    %-15s\n", rpkistat.Domain)
+	if len(rpkistat.Reasons) > 0 {
+		fmt.Fprintf(w, "Status    %s (%s)\n", rpkistat.Status, strings.Join(rpkistat.Reasons, " "))
+	} else {
+		fmt.Fprintf(w, "Status    %s\n", rpkistat.Status)
+	}
+	fmt.Fprintf(w, "Names     %2d\n", rpkistat.Names)
+	fmt.Fprintf(w, "  Full    %2d\n", rpkistat.NamesFull)
+	fmt.Fprintf(w, "  Partial %2d\n", rpkistat.NamesPartial)
+	fmt.Fprintf(w, "IPv4      %2d\n", rpkistat.IPv4)
+	fmt.Fprintf(w, "  ROAs    %2d\n", rpkistat.IPv4roas)
+	fmt.Fprintf(w, "  TA      %2d\n", rpkistat.TAs4)
+	fmt.Fprintf(w, "  AS ROAs %2d\n", rpkistat.AS4)
+	fmt.Fprintf(w, "  MaxLen invalid    %2d\n", rpkistat.IPv4maxLengthInvalid)
+	fmt.Fprintf(w, "  MaxLen permissive %2d\n", rpkistat.IPv4maxLengthPermissive)
+	fmt.Fprintf(w, "  Unknown %2d\n", rpkistat.IPv4unknown)
+	fmt.Fprintf(w, "IPv6      %2d\n", rpkistat.IPv6)
+	fmt.Fprintf(w, "  ROAs    %2d\n", rpkistat.IPv6roas)
+	fmt.Fprintf(w, "  TA      %2d\n", rpkistat.TAs6)
+	fmt.Fprintf(w, "  AS ROAs %2d\n", rpkistat.AS6)
+	fmt.Fprintf(w, "  MaxLen invalid    %2d\n", rpkistat.IPv6maxLengthInvalid)
+	fmt.Fprintf(w, "  MaxLen permissive %2d\n", rpkistat.IPv6maxLengthPermissive)
+	fmt.Fprintf(w, "  Unknown %2d\n", rpkistat.IPv6unknown)
+	if origins.Load() != nil {
+		fmt.Fprintf(w, "IPv4 ROV\n")
+		fmt.Fprintf(w, "  Valid       %2d\n", rpkistat.IPv4valid)
+		fmt.Fprintf(w, "  Invalid AS  %2d\n", rpkistat.IPv4invalidAS)
+		fmt.Fprintf(w, "  Invalid Len %2d\n", rpkistat.IPv4invalidLength)
+		fmt.Fprintf(w, "  Not found   %2d\n", rpkistat.IPv4notFound)
+		fmt.Fprintf(w, "IPv6 ROV\n")
+		fmt.Fprintf(w, "  Valid       %2d\n", rpkistat.IPv6valid)
+		fmt.Fprintf(w, "  Invalid AS  %2d\n", rpkistat.IPv6invalidAS)
+		fmt.Fprintf(w, "  Invalid Len %2d\n", rpkistat.IPv6invalidLength)
+		fmt.Fprintf(w, "  Not found   %2d\n", rpkistat.IPv6notFound)
+	}
+	if delegationEnabled() {
+		fmt.Fprintf(w, "NS set    %s\n", rpkistat.NSSet)
+		fmt.Fprintf(w, "  Parent only %2d coverage %s\n", rpkistat.ParentOnly, rpkistat.ParentCoverage)
+		fmt.Fprintf(w, "  Child only  %2d coverage %s\n", rpkistat.ChildOnly, rpkistat.ChildCoverage)
+		fmt.Fprintf(w, "  Glue        %2d mismatch %d\n", rpkistat.GlueNames, rpkistat.GlueMismatch)
+	}
+	if rpkistat.Probed {
+		fmt.Fprintf(w, "Serving\n")
+		fmt.Fprintf(w, "  IPv4    %2d ROAs %2d\n", rpkistat.IPv4serving, rpkistat.IPv4servingROAs)
+		fmt.Fprintf(w, "  IPv6    %2d ROAs %2d\n", rpkistat.IPv6serving, rpkistat.IPv6servingROAs)
+		fmt.Fprintf(w, "  Serials %2d\n", rpkistat.Serials)
+	}
+	if rpkistat.ResolversCompared {
+		fmt.Fprintf(w, "Resolver differences %2d\n", rpkistat.ResolverDifferences)
+	}
+	if errs := rpkistat.ErrServfail + rpkistat.ErrTimeout + rpkistat.ErrNxdomain + rpkistat.ErrDNS + rpkistat.ErrROA; errs > 0 {
+		fmt.Fprintf(w, "Errors    %2d\n", errs)
+		fmt.Fprintf(w, "  SERVFAIL %2d\n", rpkistat.ErrServfail)
+		fmt.Fprintf(w, "  Timeout  %2d\n", rpkistat.ErrTimeout)
+		fmt.Fprintf(w, "  NXDOMAIN %2d\n", rpkistat.ErrNxdomain)
+		fmt.Fprintf(w, "  DNS      %2d\n", rpkistat.ErrDNS)
+		fmt.Fprintf(w, "  ROA      %2d\n", rpkistat.ErrROA)
+	}
+}
+
+// printDetail prints the nameservers and addresses of a result
+//
+//	ns1.example.            partial
+//	  192.0.2.1             192.0.2.0/24 (announced)  ROA AS64496 [ripe] valid
+//	  2001:db8::1           2001:db8::/64 (masked)    no ROA
+func printDetail(w io.Writer, result *DomainResult) {
+	fmt.Fprintf(w, "Nameservers of %s\n", result.Stat.Domain)
+	for _, ns := range result.Nameservers {
+		line := fmt.Sprintf("%-30s %s", ns.Name, ns.Coverage)
+		if ns.Error != "" {
+			line += " (" + ns.Error + ")"
+		}
+		if ns.GlueMismatch {
+			line += " glue-mismatch"
+		}
+		fmt.Fprintln(w, line)
+		for _, addr := range ns.Addresses {
+			line := fmt.Sprintf("  %-28s %-22s %-11s", addr.Ip, addr.Prefix, "("+addr.PrefixSource+")")
+			switch {
+			case addr.Unknown:
+				line += " unknown"
+			case addr.Roa == nil:
+				line += " no ROA"
+			default:
+				line += fmt.Sprintf(" ROA %s [%s]", strings.Join(addr.Roa.Asn, ","), strings.Join(addr.Roa.Ta, ","))
+				for _, vrp := range addr.Roa.Vrps {
+					line += fmt.Sprintf(" %s-%d", vrp.Prefix, vrp.MaxLength)
+				}
+				if addr.Roa.MaxLengthInvalid {
+					line += " maxlength-invalid"
+				}
+				if addr.Roa.MaxLengthPermissive {
+					line += " permissive"
+				}
+			}
+			if addr.Probe != nil {
+				line += " " + addr.Probe.String()
+			}
+			if addr.Source != "" && addr.Source != ADDRESS_RESOLVED {
+				line += " [" + addr.Source + "]"
+			}
+			if addr.Validity != nil {
+				line += fmt.Sprintf(" AS%d %s", addr.Validity.Origin, addr.Validity.State)
+				if addr.Validity.Reason != "" {
+					line += "(" + addr.Validity.Reason + ")"
+				}
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	if d := result.Delegation; d != nil {
+		fmt.Fprintf(w, "Delegation in %s (server %s)\n", d.Zone, d.Server)
+		for _, ns := range d.Parent {
+			fmt.Fprintf(w, "  %-28s %s\n", ns, strings.Join(d.Glue[ns], " "))
+		}
+		if len(d.ParentOnly) > 0 {
+			fmt.Fprintf(w, "Only in parent %s\n", strings.Join(d.ParentOnly, " "))
+		}
+		if len(d.ChildOnly) > 0 {
+			fmt.Fprintf(w, "Only in child  %s\n", strings.Join(d.ChildOnly, " "))
+		}
+	}
+	for _, diff := range result.ResolverDifferences {
+		fmt.Fprintf(w, "Resolvers disagree on %s %s\n", diff.Name, diff.Qtype)
+		resolvers := make([]string, 0, len(diff.Answers)+len(diff.Errors))
+		for resolver := range diff.Answers {
+			resolvers = append(resolvers, resolver)
+		}
+		for resolver := range diff.Errors {
+			resolvers = append(resolvers, resolver)
+		}
+		sort.Strings(resolvers)
+		for _, resolver := range resolvers {
+			if category, ok := diff.Errors[resolver]; ok {
+				fmt.Fprintf(w, "  %-28s error %s\n", resolver, category)
+			} else if len(diff.Answers[resolver]) == 0 {
+				fmt.Fprintf(w, "  %-28s no records\n", resolver)
+			} else {
+				fmt.Fprintf(w, "  %-28s %s\n", resolver, strings.Join(diff.Answers[resolver], " "))
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+// every domain has at least one row in detailed CSV output
+func TestWriteResultsCSVDetail(t *testing.T) {
+	date := time.Now().UTC()
+	results := []*DomainResult{
+		testResult(),
+		// failed, no nameservers
+		{Stat: &RPKIstat{Domain: "failed", Date: date, Status: STATUS_FAILED, Reasons: []string{REASON_NS + ERROR_NXDOMAIN}}, Nameservers: []*NameserverResult{}},
+		// a nameserver without addresses
+		{Stat: &RPKIstat{Domain: "noaddress", Date: date, Names: 1, Status: STATUS_FAILED}, Nameservers: []*NameserverResult{
+			{Name: "ns.noaddress.", Coverage: COVERAGE_NONE, Error: ERROR_NXDOMAIN, Addresses: []*AddressResult{}},
+		}},
+	}
+
+	var out bytes.Buffer
+	if err := writeResults(&out, OUTPUT_CSV, results, true); err != nil {
+		t.Fatalf("writeResults: %s", err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %s", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d records, want header and 3 rows", len(records))
+	}
+	stats := len(statCSVHeader())
+	tests := []struct {
+		domain     string
+		nameserver string
+		ip         string
+	}{
+		{"example", "ns1.example.net.", "192.0.2.1"},
+		{"failed", "", ""},
+		{"noaddress", "ns.noaddress.", ""},
+	}
+	for i, tt := range tests {
+		record := records[i+1]
+		if len(record) != stats+len(detailCSVHeader()) {
+			t.Errorf("%s: %d columns, want %d", tt.domain, len(record), stats+len(detailCSVHeader()))
+			continue
+		}
+		if record[0] != tt.domain || record[stats] != tt.nameserver || record[stats+2] != tt.ip {
+			t.Errorf("row %d is %s %q %q, want %s %q %q", i+1, record[0], record[stats], record[stats+2], tt.domain, tt.nameserver, tt.ip)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+)
+
+// number of SOA queries sent to an address before it counts as unreachable
+const PROBE_TRIES = 2
+
+// Probe is the answer of a nameserver address to an SOA query for the domain
+type Probe struct {
+	// the address answered at all
+	Answered bool `json:"answered"`
+	// the answer has the AA flag and the SOA of the domain
+	Authoritative bool   `json:"authoritative"`
+	Serial        uint32 `json:"serial"`
+	// round trip time in milliseconds
+	RTT float64 `json:"rtt_ms"`
+	// category of a failed query
+	Error string `json:"error,omitempty"`
+}
+
+// probeAddresses sends an SOA query for domain to every nameserver address
+func probeAddresses(domain string, l *lookups) {
+	l.probes = make(map[string]*Probe)
+	for ns := range l.name2ip4 {
+		for _, ip := range append(append([]string{}, l.name2ip4[ns]...), l.name2ip6[ns]...) {
+			if _, ok := l.probes[ip]; ok {
+				// already done
+				continue
+			}
+			l.probes[ip] = probe(domain, ip)
+		}
+	}
+}
+
+// probe asks a single address for the SOA of domain without recursion
+func probe(domain string, ip string) *Probe {
+	zone := dns.Fqdn(domain)
+	query := new(dns.Msg)
+	query.SetQuestion(zone, dns.TypeSOA)
+	query.RecursionDesired = false
+
+	client := new(dns.Client)
+	client.Timeout = TIMEOUT * 1e9
+	address := net.JoinHostPort(ip, "53")
+
+	result := &Probe{}
+	for try := 0; try < PROBE_TRIES; try++ {
+		dnsLimit.acquire()
+		r, rtt, err := client.Exchange(query, address)
+		dnsLimit.release()
+
+		if err != nil {
+			log.Debugf("%-30s: SOA query to %s failed: %s", zone, address, err)
+			result.Error = ERROR_DNS
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				result.Error = ERROR_TIMEOUT
+			}
+			continue
+		}
+
+		result.Answered = true
+		result.Error = ""
+		result.RTT = float64(rtt.Microseconds()) / 1000
+		switch r.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeServerFailure:
+			result.Error = ERROR_SERVFAIL
+		case dns.RcodeNameError:
+			result.Error = ERROR_NXDOMAIN
+		default:
+			result.Error = ERROR_DNS
+		}
+		for _, rr := range r.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && dns.CanonicalName(soa.Hdr.Name) == dns.CanonicalName(zone) {
+				result.Serial = soa.Serial
+				result.Authoritative = r.Authoritative && r.Rcode == dns.RcodeSuccess
+			}
+		}
+		log.Debugf("%-30s: SOA from %s serial %d aa %t rtt %s", zone, address, result.Serial, result.Authoritative, rtt)
+		break
+	}
+	return result
+}
+
+// String describes a probe in a few words
+func (p *Probe) String() string {
+	switch {
+	case !p.Answered:
+		return fmt.Sprintf("no answer (%s)", p.Error)
+	case p.Authoritative:
+		return fmt.Sprintf("soa %d %.1fms", p.Serial, p.RTT)
+	case p.Error != "":
+		return fmt.Sprintf("%s %.1fms", p.Error, p.RTT)
+	}
+	return fmt.Sprintf("not authoritative %.1fms", p.RTT)
+}
@@ -0,0 +1,370 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/spf13/cobra"
+)
+
+// Kinds of reports
+const REPORT_DAILY string = "daily"
+const REPORT_SUMMARY string = "summary"
+const REPORT_CHANGES string = "changes"
+
+const REPORT_KIND string = "kind"
+const REPORT_FROM string = "from"
+const REPORT_TO string = "to"
+const REPORT_TLD string = "tld"
+const REPORT_TOP string = "top"
+
+const DATE_FORMAT string = "2006-01-02"
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report on stored RPKI statistics",
+	Long: `Report on the RPKI statistics stored in the database
+
+  daily    coverage per day (share of fully covered domains, IPv4 and IPv6 addresses with ROAs)
+  summary  coverage over the whole period using the latest result per domain
+  changes  domains that gained or lost most coverage between first and last result
+
+Failed measurements carry no data. They are left out of the coverage
+and counted as failed if a domain has no other result in the period.`,
+	Run: execReport,
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	// report flags are read from the command, they are not configuration
+	reportCmd.Flags().StringP(REPORT_KIND, "k", REPORT_DAILY, "kind of report: daily, summary or changes")
+	reportCmd.Flags().String(REPORT_FROM, "", "first day (YYYY-MM-DD) of the report (default 30 days ago)")
+	reportCmd.Flags().String(REPORT_TO, "", "last day (YYYY-MM-DD) of the report (default today)")
+	reportCmd.Flags().StringSlice(REPORT_TLD, nil, "only report on these domains (repeat or comma separated)")
+	reportCmd.Flags().Int(REPORT_TOP, 10, "number of domains listed as gainers and losers")
+	reportCmd.Flags().StringP(OUTPUT, OUTPUT_SHORT, OUTPUT_TEXT, "output format: text, json or csv")
+}
+
+// Coverage aggregates the results of one period
+type Coverage struct {
+	Period    string  `json:"period"`
+	Domains   int     `json:"domains"`
+	Full      int     `json:"full"`
+	Partial   int     `json:"partial"`
+	None      int     `json:"none"`
+	Failed    int     `json:"failed"`
+	FullShare float64 `json:"full_share"`
+	IPv4      int     `json:"ipv4"`
+	IPv4roas  int     `json:"ipv4_roas"`
+	IPv4Share float64 `json:"ipv4_share"`
+	IPv6      int     `json:"ipv6"`
+	IPv6roas  int     `json:"ipv6_roas"`
+	IPv6Share float64 `json:"ipv6_share"`
+}
+
+// CoverageChange is the change of address coverage of one domain
+type CoverageChange struct {
+	Domain string  `json:"domain"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Change float64 `json:"change"`
+}
+
+func execReport(cmd *cobra.Command, args []string) {
+	kind, _ := cmd.Flags().GetString(REPORT_KIND)
+	format, _ := cmd.Flags().GetString(OUTPUT)
+	tlds, _ := cmd.Flags().GetStringSlice(REPORT_TLD)
+	top, _ := cmd.Flags().GetInt(REPORT_TOP)
+
+	switch format {
+	case OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_CSV:
+	default:
+		cmd.Help()
+		log.Fatalf("Unknown output format %s", format)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := parseReportDate(cmd, REPORT_FROM, today.AddDate(0, 0, -30))
+	to := parseReportDate(cmd, REPORT_TO, today)
+	log.Debugf("Report %s from %s to %s, domains %v", kind, from.Format(DATE_FORMAT), to.Format(DATE_FORMAT), tlds)
+
+	db, err := openDB()
+	if err != nil {
+		log.Fatalf("Could not open database: %s", err)
+	}
+	defer db.Close()
+	rows, err := loadReportRows(db, from, to.AddDate(0, 0, 1), tlds)
+	if err != nil {
+		log.Fatalf("Could not read results: %s", err)
+	}
+	log.Debugf("Report on %d results", len(rows))
+
+	switch kind {
+	case REPORT_DAILY:
+		err = writeCoverage(os.Stdout, format, dailyCoverage(rows))
+	case REPORT_SUMMARY:
+		period := from.Format(DATE_FORMAT) + ".." + to.Format(DATE_FORMAT)
+		err = writeCoverage(os.Stdout, format, []*Coverage{coverage(period, latestPerDomain(rows))})
+	case REPORT_CHANGES:
+		gained, lost := coverageChanges(rows, top)
+		err = writeChanges(os.Stdout, format, gained, lost)
+	default:
+		cmd.Help()
+		log.Fatalf("Unknown report %s", kind)
+	}
+	if err != nil {
+		log.Fatalf("Could not write report: %s", err)
+	}
+}
+
+func parseReportDate(cmd *cobra.Command, flag string, def time.Time) time.Time {
+	value, _ := cmd.Flags().GetString(flag)
+	if value == "" {
+		return def
+	}
+	date, err := time.Parse(DATE_FORMAT, value)
+	if err != nil {
+		log.Fatalf("Could not parse --%s %s: %s", flag, value, err)
+	}
+	return date
+}
+
+// loadReportRows reads all results with from <= TESTDATE < to
+func loadReportRows(db *Storage, from, to time.Time, tlds []string) ([]*RPKIstat, error) {
+	query := "SELECT TESTDATE, TLD, NAMES, NAMES_ROA_FULL, NAMES_ROA_PARTIAL, IP4S, IP4S_ROAS, IP6S, IP6S_ROAS, STATUS FROM RPKI WHERE TESTDATE >= ? AND TESTDATE < ?"
+	args := []interface{}{from, to}
+	if len(tlds) > 0 {
+		query += " AND TLD IN (?" + strings.Repeat(", ?", len(tlds)-1) + ")"
+		for _, tld := range tlds {
+			args = append(args, strings.ToLower(tld))
+		}
+	}
+	query += " ORDER BY TESTDATE"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]*RPKIstat, 0)
+	for rows.Next() {
+		r := &RPKIstat{}
+		if err := rows.Scan(&r.Date, &r.Domain, &r.Names, &r.NamesFull, &r.NamesPartial, &r.IPv4, &r.IPv4roas, &r.IPv6, &r.IPv6roas, &r.Status); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// latestPerDomain keeps only the last result of every domain (rows are ordered by date),
+// a failed result only if the domain has no other
+func latestPerDomain(rows []*RPKIstat) []*RPKIstat {
+	latest := make(map[string]*RPKIstat)
+	for _, r := range rows {
+		if prev, ok := latest[r.Domain]; ok && r.failed() && !prev.failed() {
+			continue
+		}
+		latest[r.Domain] = r
+	}
+	result := make([]*RPKIstat, 0, len(latest))
+	for _, r := range latest {
+		result = append(result, r)
+	}
+	return result
+}
+
+func coverage(period string, rows []*RPKIstat) *Coverage {
+	c := &Coverage{Period: period}
+	for _, r := range rows {
+		if r.failed() {
+			c.Failed++
+			continue
+		}
+		c.Domains++
+		switch domainCoverage(r) {
+		case COVERAGE_FULL:
+			c.Full++
+		case COVERAGE_PARTIAL:
+			c.Partial++
+		default:
+			c.None++
+		}
+		c.IPv4 += r.IPv4
+		c.IPv4roas += r.IPv4roas
+		c.IPv6 += r.IPv6
+		c.IPv6roas += r.IPv6roas
+	}
+	c.FullShare = share(c.Full, c.Domains)
+	c.IPv4Share = share(c.IPv4roas, c.IPv4)
+	c.IPv6Share = share(c.IPv6roas, c.IPv6)
+	return c
+}
+
+func share(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+// dailyCoverage aggregates the last result of every domain per day
+func dailyCoverage(rows []*RPKIstat) []*Coverage {
+	days := make(map[string][]*RPKIstat)
+	for _, r := range rows {
+		day := r.Date.UTC().Format(DATE_FORMAT)
+		days[day] = append(days[day], r)
+	}
+	keys := make([]string, 0, len(days))
+	for day := range days {
+		keys = append(keys, day)
+	}
+	sort.Strings(keys)
+
+	result := make([]*Coverage, 0, len(keys))
+	for _, day := range keys {
+		result = append(result, coverage(day, latestPerDomain(days[day])))
+	}
+	return result
+}
+
+// coverageChanges compares the first and last result of every domain
+// and returns the top domains that gained and lost address coverage
+func coverageChanges(rows []*RPKIstat, top int) (gained, lost []*CoverageChange) {
+	first := make(map[string]*RPKIstat)
+	last := make(map[string]*RPKIstat)
+	for _, r := range rows {
+		if r.failed() {
+			continue
+		}
+		if _, ok := first[r.Domain]; !ok {
+			first[r.Domain] = r
+		}
+		last[r.Domain] = r
+	}
+
+	changes := make([]*CoverageChange, 0)
+	for domain, f := range first {
+		l := last[domain]
+		before := share(f.IPv4roas+f.IPv6roas, f.IPv4+f.IPv6)
+		after := share(l.IPv4roas+l.IPv6roas, l.IPv4+l.IPv6)
+		if before == after {
+			continue
+		}
+		changes = append(changes, &CoverageChange{
+			Domain: domain,
+			From:   f.Date.UTC().Format(DATE_FORMAT),
+			To:     l.Date.UTC().Format(DATE_FORMAT),
+			Before: before,
+			After:  after,
+			Change: after - before,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change > changes[j].Change
+		}
+		return changes[i].Domain < changes[j].Domain
+	})
+
+	gained = make([]*CoverageChange, 0)
+	lost = make([]*CoverageChange, 0)
+	for _, c := range changes {
+		if c.Change > 0 && len(gained) < top {
+			gained = append(gained, c)
+		}
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Change < 0 && len(lost) < top {
+			lost = append(lost, changes[i])
+		}
+	}
+	return
+}
+
+func writeCoverage(w io.Writer, format string, coverages []*Coverage) error {
+	switch format {
+	case OUTPUT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(coverages)
+	case OUTPUT_CSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"period", "domains", "full", "partial", "none", "failed", "full_share", "ipv4", "ipv4_roas", "ipv4_share", "ipv6", "ipv6_roas", "ipv6_share"})
+		for _, c := range coverages {
+			writer.Write([]string{c.Period, strconv.Itoa(c.Domains), strconv.Itoa(c.Full), strconv.Itoa(c.Partial), strconv.Itoa(c.None), strconv.Itoa(c.Failed), formatShare(c.FullShare),
+				strconv.Itoa(c.IPv4), strconv.Itoa(c.IPv4roas), formatShare(c.IPv4Share), strconv.Itoa(c.IPv6), strconv.Itoa(c.IPv6roas), formatShare(c.IPv6Share)})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	fmt.Fprintf(w, "%-22s %7s %7s %7s %7s %7s %6s %7s %6s %7s %6s\n", "Period", "Domains", "Full", "Partial", "None", "Failed", "Full%", "IPv4", "ROA%", "IPv6", "ROA%")
+	for _, c := range coverages {
+		fmt.Fprintf(w, "%-22s %7d %7d %7d %7d %7d %5.1f%% %7d %5.1f%% %7d %5.1f%%\n", c.Period, c.Domains, c.Full, c.Partial, c.None, c.Failed, 100*c.FullShare,
+			c.IPv4, 100*c.IPv4Share, c.IPv6, 100*c.IPv6Share)
+	}
+	return nil
+}
+
+func writeChanges(w io.Writer, format string, gained, lost []*CoverageChange) error {
+	switch format {
+	case OUTPUT_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string][]*CoverageChange{"gained": gained, "lost": lost})
+	case OUTPUT_CSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"direction", "domain", "from", "to", "before", "after", "change"})
+		for _, c := range gained {
+			writer.Write([]string{"gained", c.Domain, c.From, c.To, formatShare(c.Before), formatShare(c.After), formatShare(c.Change)})
+		}
+		for _, c := range lost {
+			writer.Write([]string{"lost", c.Domain, c.From, c.To, formatShare(c.Before), formatShare(c.After), formatShare(c.Change)})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	for _, part := range []struct {
+		title   string
+		changes []*CoverageChange
+	}{{"Gained coverage", gained}, {"Lost coverage", lost}} {
+		fmt.Fprintf(w, "%s\n", part.title)
+		for _, c := range part.changes {
+			fmt.Fprintf(w, "  %-30s %s %5.1f%% -> %s %5.1f%% (%+.1f)\n", c.Domain, c.From, 100*c.Before, c.To, 100*c.After, 100*c.Change)
+		}
+	}
+	return nil
+}
+
+func formatShare(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
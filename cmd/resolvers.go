@@ -0,0 +1,116 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/miekg/dns"
+)
+
+// ResolverDifference is a name the resolvers answered differently
+type ResolverDifference struct {
+	Name  string `json:"name"`
+	Qtype string `json:"qtype"`
+	// answers and error categories per resolver
+	Answers map[string][]string `json:"answers"`
+	Errors  map[string]string   `json:"errors,omitempty"`
+}
+
+// compareResolvers asks every resolver for the NS set of domain and for the
+// addresses of all nameservers any of them returned
+func compareResolvers(domain string) []*ResolverDifference {
+	differences := make([]*ResolverDifference, 0)
+	resolvers, err := getResolvers()
+	if err != nil {
+		log.Errorf("Could not compare resolvers: %s", err)
+		return differences
+	}
+
+	diff, nameservers := compareAnswers(resolvers, domain, dns.TypeNS)
+	if diff != nil {
+		differences = append(differences, diff)
+	}
+	for _, ns := range nameservers {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if diff, _ := compareAnswers(resolvers, ns, qtype); diff != nil {
+				differences = append(differences, diff)
+			}
+		}
+	}
+	if len(differences) > 0 {
+		log.Warnf("%s: resolvers disagree on %d names", domain, len(differences))
+	}
+	return differences
+}
+
+// compareAnswers asks every resolver for name. It returns a difference if the
+// answers are not the same (nil otherwise) and the union of all answers.
+func compareAnswers(resolvers []Resolver, name string, qtype uint16) (*ResolverDifference, []string) {
+	diff := &ResolverDifference{
+		Name:    dns.Fqdn(name),
+		Qtype:   dns.TypeToString[qtype],
+		Answers: make(map[string][]string),
+		Errors:  make(map[string]string),
+	}
+	union := make([]string, 0)
+	different := make(map[string]bool)
+	for _, resolver := range resolvers {
+		msg, err := resolveWith([]Resolver{resolver}, name, qtype)
+		if err != nil {
+			diff.Errors[resolver.String()] = errorCategory(err)
+			different["error "+errorCategory(err)] = true
+			continue
+		}
+		answer := answerStrings(msg, qtype)
+		diff.Answers[resolver.String()] = answer
+		different[strings.Join(answer, " ")] = true
+		union = append(union, answer...)
+	}
+	union = unique(union)
+	sort.Strings(union)
+	if len(different) > 1 {
+		return diff, union
+	}
+	return nil, union
+}
+
+// answerStrings returns the sorted records of type qtype in the answer section
+func answerStrings(msg *dns.Msg, qtype uint16) []string {
+	answer := make([]string, 0)
+	for _, rr := range msg.Answer {
+		switch rr := rr.(type) {
+		case *dns.NS:
+			if qtype == dns.TypeNS {
+				answer = append(answer, strings.ToLower(rr.Ns))
+			}
+		case *dns.A:
+			if qtype == dns.TypeA {
+				answer = append(answer, rr.A.String())
+			}
+		case *dns.AAAA:
+			if qtype == dns.TypeAAAA {
+				answer = append(answer, rr.AAAA.String())
+			}
+		}
+	}
+	answer = unique(answer)
+	sort.Strings(answer)
+	return answer
+}
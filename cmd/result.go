@@ -0,0 +1,162 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"sort"
+)
+
+// ROA coverage of a nameserver
+const COVERAGE_FULL string = "full"
+const COVERAGE_PARTIAL string = "partial"
+const COVERAGE_NONE string = "none"
+const COVERAGE_UNKNOWN string = "unknown"
+
+// status of a measurement
+const STATUS_OK string = "ok"
+const STATUS_PARTIAL string = "partial"
+const STATUS_FAILED string = "failed"
+
+// reason codes of partial and failed measurements,
+// prefixes are followed by the error category
+const REASON_NS string = "ns_"
+const REASON_NO_NAMESERVERS string = "no_nameservers"
+const REASON_ADDRESS string = "address_"
+const REASON_ROA_UNAVAILABLE string = "roa_unavailable"
+const REASON_VALIDITY_UNAVAILABLE string = "validity_unavailable"
+const REASON_PARENT_FAILED string = "parent_failed"
+const REASON_CHILD_FAILED string = "child_failed"
+const REASON_RESOLVERS_DIFFER string = "resolvers_differ"
+
+// origin of a nameserver address
+const ADDRESS_RESOLVED string = "resolved"
+const ADDRESS_GLUE string = "glue"
+const ADDRESS_BOTH string = "both"
+
+// DomainResult is the detailed result of domainStat
+type DomainResult struct {
+	Stat        *RPKIstat           `json:"stat"`
+	Nameservers []*NameserverResult `json:"nameservers"`
+	// only with --delegation
+	Delegation *DelegationResult `json:"delegation,omitempty"`
+	// only in resolver compare mode
+	ResolverDifferences []*ResolverDifference `json:"resolver_differences,omitempty"`
+}
+
+// NameserverResult holds the addresses of one nameserver
+type NameserverResult struct {
+	Name     string `json:"name"`
+	Coverage string `json:"coverage"`
+	// category of a failed address lookup
+	Error string `json:"error,omitempty"`
+	// glue in the parent differs from the resolved addresses
+	GlueMismatch bool             `json:"glue_mismatch"`
+	Addresses    []*AddressResult `json:"addresses"`
+}
+
+// AddressResult holds everything we know about one nameserver address
+type AddressResult struct {
+	Ip           string `json:"ip"`
+	Prefix       string `json:"prefix"`
+	PrefixSource string `json:"prefix_source"`
+	// nil if no ROA covers the prefix
+	Roa *ROA `json:"roa,omitempty"`
+	// nil if no announcement is known
+	Validity *Validity `json:"validity,omitempty"`
+	// the ROA source could not answer
+	Unknown bool `json:"unknown"`
+	// resolved, glue or both
+	Source string `json:"source"`
+	// answer to the SOA query, only with --probe
+	Probe *Probe `json:"probe,omitempty"`
+}
+
+// buildResult collects the intermediate data of domainStat into a DomainResult
+func buildResult(stat *RPKIstat, nameservers []string, l *lookups, nsErrors map[string]string) *DomainResult {
+	result := &DomainResult{Stat: stat, Nameservers: make([]*NameserverResult, 0)}
+
+	names := append([]string{}, nameservers...)
+	sort.Strings(names)
+	for _, name := range names {
+		ns := &NameserverResult{Name: name, Error: nsErrors[name], GlueMismatch: l.glueMismatch(name), Addresses: make([]*AddressResult, 0)}
+
+		ips := append(append([]string{}, l.name2ip4[name]...), l.name2ip6[name]...)
+		sort.Strings(ips)
+		for _, ip := range ips {
+			addr := &AddressResult{Ip: ip, Roa: l.roas[ip], Validity: l.validities[ip], Unknown: l.unknown[ip] > 0, Source: l.addressSource(name, ip), Probe: l.probes[ip]}
+			if addr.Roa != nil {
+				addr.Prefix = addr.Roa.Prefix
+				addr.PrefixSource = addr.Roa.PrefixSource
+			} else if prefix, source, err := ip2prefix(ip); err == nil {
+				addr.Prefix = prefix.String()
+				addr.PrefixSource = source
+			}
+			ns.Addresses = append(ns.Addresses, addr)
+		}
+
+		ns.Coverage = nameCoverage(ips, l)
+		result.Nameservers = append(result.Nameservers, ns)
+	}
+	return result
+}
+
+// nameCoverage classifies a nameserver by the ROA coverage of its addresses.
+// Addresses the ROA source could not answer for are neither covered nor
+// uncovered, a name is unknown unless its known addresses decide.
+func nameCoverage(ips []string, l *lookups) string {
+	covered, unknown := 0, 0
+	for _, ip := range ips {
+		if l.roas[ip] != nil {
+			covered++
+		} else if l.unknown[ip] > 0 {
+			unknown++
+		}
+	}
+	switch {
+	case covered > 0 && covered == len(ips):
+		return COVERAGE_FULL
+	case covered > 0 && covered+unknown < len(ips):
+		return COVERAGE_PARTIAL
+	case unknown > 0:
+		return COVERAGE_UNKNOWN
+	}
+	return COVERAGE_NONE
+}
+
+// failed is true if the measurement produced no data
+func (s *RPKIstat) failed() bool {
+	return s.Status == STATUS_FAILED
+}
+
+// measuredCoverage is the domain coverage, empty if the measurement failed
+func measuredCoverage(stat *RPKIstat) string {
+	if stat.failed() {
+		return ""
+	}
+	return domainCoverage(stat)
+}
+
+// domainCoverage classifies a domain by the ROA coverage of its nameservers
+func domainCoverage(stat *RPKIstat) string {
+	switch {
+	case stat.Names > 0 && stat.NamesFull == stat.Names:
+		return COVERAGE_FULL
+	case stat.NamesFull > 0 || stat.NamesPartial > 0:
+		return COVERAGE_PARTIAL
+	}
+	return COVERAGE_NONE
+}
@@ -0,0 +1,247 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/spf13/viper"
+)
+
+// Where the prefix used for a ROA lookup came from
+const PREFIX_ANNOUNCED string = "announced"
+const PREFIX_MASKED string = "masked"
+
+type ROA struct {
+	Ip           string   `json:"-"`
+	Prefix       string   `json:"-"`
+	PrefixSource string   `json:"-"`
+	Asn          []string `json:"asns"`
+	Ta           []string `json:"tas"`
+	// all VRPs covering Prefix with their max length
+	Vrps []VRP `json:"vrps"`
+	// no covering VRP allows the length of the announced prefix
+	MaxLengthInvalid bool `json:"maxlength_invalid"`
+	// a covering VRP has a max length longer than its prefix (RFC 9319)
+	MaxLengthPermissive bool `json:"maxlength_permissive"`
+}
+
+// Route origin validation states as reported by Routinator
+const VALIDITY_VALID string = "valid"
+const VALIDITY_INVALID string = "invalid"
+const VALIDITY_NOT_FOUND string = "not-found"
+
+// Reasons for an invalid route origin
+const INVALID_AS string = "as"
+const INVALID_LENGTH string = "length"
+
+type Validity struct {
+	Prefix string `json:"prefix"`
+	Origin uint32 `json:"origin"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ROASource answers questions about validated ROA payloads
+type ROASource interface {
+	// Lookup returns all VRPs covering prefix
+	Lookup(prefix netip.Prefix) ([]VRP, error)
+	// Validate returns the route origin validation state of prefix announced by origin
+	Validate(prefix netip.Prefix, origin uint32) (*Validity, error)
+	// Metadata describes the data set the answers are based on
+	Metadata() SourceMetadata
+}
+
+// SourceMetadata describes a ROA source
+type SourceMetadata struct {
+	Name       string
+	Serial     uint32
+	LastUpdate time.Time
+}
+
+// selectROASource returns the ROA source given on the command line
+// or nil if none is given
+func selectROASource() (ROASource, error) {
+	if viper.GetString(VRPS) != "" {
+		log.Debugf("VRP file: %s", viper.GetString(VRPS))
+		table, err := loadVRPFile(viper.GetString(VRPS))
+		if err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+	if viper.GetString(RTR) != "" {
+		log.Debugf("RTR cache: %s", viper.GetString(RTR))
+		table, err := rtrSync(viper.GetString(RTR))
+		if err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+	if viper.GetString(ROUTINATOR) != "" {
+		log.Debugf("Routinator: %s", viper.GetString(ROUTINATOR))
+		routinator, err := newRoutinatorSource(viper.GetString(ROUTINATOR))
+		if err != nil {
+			return nil, err
+		}
+		return routinator, nil
+	}
+	return nil, nil
+}
+
+// getROA returns the ROAs covering the prefix of ip or nil if there are none
+func getROA(src ROASource, ip string) (roa *ROA, err error) {
+
+	prefix, source, err := ip2prefix(ip)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("ROA lookup for %s: %s (prefix %s)", ip, prefix, source)
+
+	covering, err := src.Lookup(prefix)
+	if err != nil {
+		log.Errorf("Error looking up ROAs for %s: %s", prefix, err)
+		return nil, err
+	}
+	if len(covering) == 0 {
+		log.Debugf("No ROA found for %s", prefix)
+		return nil, nil
+	}
+
+	roa = &ROA{Ip: ip, Prefix: prefix.String(), PrefixSource: source, Vrps: covering}
+
+	ta := make([]string, 0)
+	asn := make([]string, 0)
+	allowed := false
+	for _, vrp := range covering {
+		// RTR does not tell us the trust anchor
+		if vrp.TA != "" {
+			ta = append(ta, vrp.TA)
+		}
+		asn = append(asn, fmt.Sprintf("AS%d", vrp.ASN))
+		if prefix.Bits() <= vrp.MaxLength {
+			allowed = true
+		}
+		if vrp.MaxLength > vrp.Prefix.Bits() {
+			log.Debugf("%s: permissive ROA %s-%d AS%d", ip, vrp.Prefix, vrp.MaxLength, vrp.ASN)
+			roa.MaxLengthPermissive = true
+		}
+	}
+	roa.Asn = unique(asn)
+	roa.Ta = unique(ta)
+
+	// a masked prefix is not announced, its length says nothing
+	if source == PREFIX_ANNOUNCED && !allowed {
+		log.Debugf("%s: announced prefix %s longer than max length of all ROAs", ip, prefix)
+		roa.MaxLengthInvalid = true
+	}
+	return roa, nil
+}
+
+// addressValidity validates the announcement covering ip.
+// If the prefix is announced by more than one origin the worst state wins.
+// It returns nil if no announcement for ip is known and
+// an error if none of the origins could be validated.
+func addressValidity(src ROASource, ip string) (validity *Validity, err error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	prefix, asns, ok := origins.Load().lookup(addr)
+	if !ok {
+		log.Debugf("No announcement known for %s", ip)
+		return nil, nil
+	}
+	for _, asn := range asns {
+		v, verr := src.Validate(prefix, asn)
+		if verr != nil {
+			log.Errorf("Error validating AS%d %s: %s", asn, prefix, verr)
+			err = verr
+			continue
+		}
+		if validity == nil || validityRank(v) > validityRank(validity) {
+			validity = v
+		}
+	}
+	if validity != nil {
+		err = nil
+	}
+	return
+}
+
+func validityRank(v *Validity) int {
+	switch v.State {
+	case VALIDITY_VALID:
+		return 0
+	case VALIDITY_NOT_FOUND:
+		return 1
+	}
+	return 2
+}
+
+// validate implements route origin validation as defined in RFC 6811
+// given all VRPs covering prefix
+func validate(covering []VRP, prefix netip.Prefix, asn uint32) *Validity {
+	validity := &Validity{Prefix: prefix.String(), Origin: asn, State: VALIDITY_NOT_FOUND}
+	if len(covering) == 0 {
+		return validity
+	}
+
+	validity.State = VALIDITY_INVALID
+	validity.Reason = INVALID_AS
+	for _, vrp := range covering {
+		if vrp.ASN != asn || asn == 0 {
+			continue
+		}
+		if prefix.Bits() <= vrp.MaxLength {
+			validity.State = VALIDITY_VALID
+			validity.Reason = ""
+			return validity
+		}
+		validity.Reason = INVALID_LENGTH
+	}
+	return validity
+}
+
+// ip2prefix returns the most specific announced prefix covering ip.
+// If no announcement is known, ip is masked to /24 (IPv4) or /64 (IPv6).
+func ip2prefix(ipstr string) (netip.Prefix, string, error) {
+
+	ip, err := netip.ParseAddr(ipstr)
+	if err != nil {
+		return netip.Prefix{}, "", fmt.Errorf("could not parse ip %s: %w", ipstr, err)
+	}
+
+	if announced, _, ok := origins.Load().lookup(ip); ok {
+		return announced, PREFIX_ANNOUNCED, nil
+	}
+
+	mask := 24
+	if ip.Is6() {
+		mask = 64
+	}
+	var prefix netip.Prefix
+	prefix, err = ip.Prefix(mask)
+	if err != nil {
+		return netip.Prefix{}, "", fmt.Errorf("could not mask ip %s mask %d: %w", ip, mask, err)
+	}
+	return prefix, PREFIX_MASKED, nil
+}
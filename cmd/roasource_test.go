@@ -0,0 +1,192 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// testSource holds
+//
+//	192.0.2.0/24-24     AS64496 ripe
+//	198.51.100.0/22-22  AS64497 arin
+//	2001:db8::/32-48    AS64498 ripe (permissive)
+func testSource() *VRPTable {
+	return newStaticSource("test",
+		VRP{Prefix: netip.MustParsePrefix("192.0.2.0/24"), MaxLength: 24, ASN: 64496, TA: "ripe"},
+		VRP{Prefix: netip.MustParsePrefix("198.51.100.0/22"), MaxLength: 22, ASN: 64497, TA: "arin"},
+		VRP{Prefix: netip.MustParsePrefix("2001:db8::/32"), MaxLength: 48, ASN: 64498, TA: "ripe"},
+	)
+}
+
+// testOrigins announces
+//
+//	192.0.2.0/24     AS64496          valid
+//	198.51.100.0/24  AS64497          invalid length
+//	203.0.113.0/24   AS64511          not found
+//	2001:db8::/48    AS64499          invalid AS
+//	2001:db8:1::/48  AS64498 AS64499  valid and invalid AS
+func testOrigins(t *testing.T) {
+	t.Helper()
+	saved := origins.Load()
+	origins.Store(&OriginTable{prefixes: map[netip.Prefix][]uint32{
+		netip.MustParsePrefix("192.0.2.0/24"):    {64496},
+		netip.MustParsePrefix("198.51.100.0/24"): {64497},
+		netip.MustParsePrefix("203.0.113.0/24"):  {64511},
+		netip.MustParsePrefix("2001:db8::/48"):   {64499},
+		netip.MustParsePrefix("2001:db8:1::/48"): {64498, 64499},
+	}})
+	t.Cleanup(func() { origins.Store(saved) })
+}
+
+// failingSource cannot answer any question
+type failingSource struct{}
+
+func (failingSource) Lookup(prefix netip.Prefix) ([]VRP, error) {
+	return nil, errors.New("lookup failed")
+}
+
+func (failingSource) Validate(prefix netip.Prefix, origin uint32) (*Validity, error) {
+	return nil, errors.New("validation failed")
+}
+
+func (failingSource) Metadata() SourceMetadata {
+	return SourceMetadata{Name: "failing"}
+}
+
+// validateFailingSource knows the VRPs of testSource but cannot validate
+type validateFailingSource struct {
+	*VRPTable
+}
+
+func (validateFailingSource) Validate(prefix netip.Prefix, origin uint32) (*Validity, error) {
+	return nil, errors.New("validation failed")
+}
+
+func TestGetROA(t *testing.T) {
+	testOrigins(t)
+	tests := []struct {
+		ip   string
+		want *ROA
+	}{
+		{"192.0.2.1", &ROA{Prefix: "192.0.2.0/24", PrefixSource: PREFIX_ANNOUNCED, Asn: []string{"AS64496"}, Ta: []string{"ripe"}}},
+		// the announced /24 is longer than the max length of the /22
+		{"198.51.100.1", &ROA{Prefix: "198.51.100.0/24", PrefixSource: PREFIX_ANNOUNCED, Asn: []string{"AS64497"}, Ta: []string{"arin"}, MaxLengthInvalid: true}},
+		// a masked prefix says nothing about the max length
+		{"198.51.101.1", &ROA{Prefix: "198.51.101.0/24", PrefixSource: PREFIX_MASKED, Asn: []string{"AS64497"}, Ta: []string{"arin"}}},
+		{"2001:db8::1", &ROA{Prefix: "2001:db8::/48", PrefixSource: PREFIX_ANNOUNCED, Asn: []string{"AS64498"}, Ta: []string{"ripe"}, MaxLengthPermissive: true}},
+		{"203.0.113.1", nil},
+		{"10.0.0.1", nil},
+	}
+	src := testSource()
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			roa, err := getROA(src, tt.ip)
+			if err != nil {
+				t.Fatalf("getROA: %s", err)
+			}
+			if tt.want == nil {
+				if roa != nil {
+					t.Errorf("getROA = %+v, want nil", roa)
+				}
+				return
+			}
+			if roa == nil {
+				t.Fatalf("getROA = nil, want %+v", tt.want)
+			}
+			roa.Ip = ""
+			roa.Vrps = nil
+			if !reflect.DeepEqual(roa, tt.want) {
+				t.Errorf("getROA = %+v, want %+v", roa, tt.want)
+			}
+		})
+	}
+
+	if _, err := getROA(failingSource{}, "192.0.2.1"); err == nil {
+		t.Errorf("getROA of a failing source returned no error")
+	}
+	if _, err := getROA(src, "not an ip"); err == nil {
+		t.Errorf("getROA of an invalid ip returned no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		asn    uint32
+		state  string
+		reason string
+	}{
+		{"valid", "192.0.2.0/24", 64496, VALIDITY_VALID, ""},
+		{"invalid ASN", "192.0.2.0/24", 64511, VALIDITY_INVALID, INVALID_AS},
+		{"invalid maxLength", "192.0.2.0/25", 64496, VALIDITY_INVALID, INVALID_LENGTH},
+		{"invalid AS0", "192.0.2.0/24", 0, VALIDITY_INVALID, INVALID_AS},
+		{"not found", "203.0.113.0/24", 64511, VALIDITY_NOT_FOUND, ""},
+		{"permissive", "2001:db8:ffff::/48", 64498, VALIDITY_VALID, ""},
+	}
+	src := testSource()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := netip.MustParsePrefix(tt.prefix)
+			covering, err := src.Lookup(prefix)
+			if err != nil {
+				t.Fatalf("Lookup: %s", err)
+			}
+			want := &Validity{Prefix: tt.prefix, Origin: tt.asn, State: tt.state, Reason: tt.reason}
+			if got := validate(covering, prefix, tt.asn); !reflect.DeepEqual(got, want) {
+				t.Errorf("validate = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAddressValidity(t *testing.T) {
+	testOrigins(t)
+	tests := []struct {
+		name string
+		ip   string
+		want *Validity
+	}{
+		{"valid", "192.0.2.1", &Validity{Prefix: "192.0.2.0/24", Origin: 64496, State: VALIDITY_VALID}},
+		{"invalid ASN", "2001:db8::1", &Validity{Prefix: "2001:db8::/48", Origin: 64499, State: VALIDITY_INVALID, Reason: INVALID_AS}},
+		{"invalid maxLength", "198.51.100.1", &Validity{Prefix: "198.51.100.0/24", Origin: 64497, State: VALIDITY_INVALID, Reason: INVALID_LENGTH}},
+		{"not found", "203.0.113.1", &Validity{Prefix: "203.0.113.0/24", Origin: 64511, State: VALIDITY_NOT_FOUND}},
+		// the worst state of all origins wins
+		{"multi origin", "2001:db8:1::1", &Validity{Prefix: "2001:db8:1::/48", Origin: 64499, State: VALIDITY_INVALID, Reason: INVALID_AS}},
+		{"not announced", "10.0.0.1", nil},
+	}
+	src := testSource()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validity, err := addressValidity(src, tt.ip)
+			if err != nil {
+				t.Fatalf("addressValidity: %s", err)
+			}
+			if !reflect.DeepEqual(validity, tt.want) {
+				t.Errorf("addressValidity = %+v, want %+v", validity, tt.want)
+			}
+		})
+	}
+
+	if _, err := addressValidity(failingSource{}, "192.0.2.1"); err == nil {
+		t.Errorf("addressValidity of a failing source returned no error")
+	}
+}
@@ -63,6 +63,13 @@ func init() {
 	viper.BindPFlags(rootCmd.PersistentFlags())	
 }
 
+// bindFlags binds the flags of the command to be run to viper values.
+// Commands share flag names, binding all of them in init would let the
+// last command win.
+func bindFlags(cmd *cobra.Command, args []string) {
+	viper.BindPFlags(cmd.Flags())
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if viper.GetString(CONFIG_FILE) != "" {
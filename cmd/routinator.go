@@ -17,85 +17,159 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
-//	"fmt"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/netip"
-	"encoding/json"
-	
-	"github.com/apex/log"
+	"net/url"
+	"path"
+	"sync"
+	"time"
 
-	"github.com/spf13/viper"
+	"github.com/apex/log"
 )
 
-type ROA struct {
-	Ip string
-	Prefix string
-	Asn []string
-	Ta []string
-}
+// routinatorLimit bounds the number of Routinator requests in flight
+var routinatorLimit limiter
 
-func getROA(ip string) (roa *ROA) {
+// RoutinatorSource asks the HTTP API of a Routinator instance
+type RoutinatorSource struct {
+	// url the prefix is appended to, e.g. http://localhost:8323/json?select-prefix=
+	url string
+	// routinator url without the json endpoint, e.g. http://localhost:8323
+	// or https://example.net/routinator behind a reverse proxy
+	base string
 
-	prefix := ip2prefix(ip)
-	url := viper.GetString(ROUTINATOR) + prefix
-	log.Debugf("Routinator URL: %s", url)
+	client *http.Client
 
-	roa = &ROA{Ip: ip, Prefix: prefix, Asn: make([]string, 0), Ta: make([]string, 0)}
+	// metadata is updated by concurrent lookups
+	mutex    sync.Mutex
+	metadata SourceMetadata
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Errorf("Error contacting routinator: %s", err)
-		return nil
+func newRoutinatorSource(routinator string) (*RoutinatorSource, error) {
+	u, err := url.Parse(routinator)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("could not parse routinator url %s", routinator)
 	}
-	
-	var response map[string]interface{}
-	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		log.Errorf("Error decoding received data: %s", err)
-		return nil
+	// the API lives next to the json endpoint
+	prefix := path.Dir(u.Path)
+	if prefix == "." || prefix == "/" {
+		prefix = ""
 	}
+	return &RoutinatorSource{
+		url:      routinator,
+		base:     u.Scheme + "://" + u.Host + prefix,
+		client:   &http.Client{Timeout: ROUTINATOR_TIMEOUT * time.Second},
+		metadata: SourceMetadata{Name: "routinator " + u.Host},
+	}, nil
+}
+
+func (r *RoutinatorSource) Metadata() SourceMetadata {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.metadata
+}
+
+// routinatorResponse is the json output format of routinator
+type routinatorResponse struct {
+	Metadata struct {
+		Generated     int64  `json:"generated"`
+		GeneratedTime string `json:"generatedTime"`
+	} `json:"metadata"`
+	Roas []struct {
+		ASN       string `json:"asn"`
+		Prefix    string `json:"prefix"`
+		MaxLength int    `json:"maxLength"`
+		TA        string `json:"ta"`
+	} `json:"roas"`
+}
 
-	roaraw := response["roas"].([]interface{})
+// routinatorValidity is the answer of the validity API of routinator
+type routinatorValidity struct {
+	ValidatedRoute struct {
+		Route struct {
+			OriginASN string `json:"origin_asn"`
+			Prefix    string `json:"prefix"`
+		} `json:"route"`
+		Validity struct {
+			State       string `json:"state"`
+			Reason      string `json:"reason"`
+			Description string `json:"description"`
+		} `json:"validity"`
+	} `json:"validated_route"`
+}
+
+// get fetches url from routinator and decodes the json answer into v
+func (r *RoutinatorSource) get(url string, v interface{}) error {
+	log.Debugf("Routinator URL: %s", url)
 
-	if len(roaraw) == 0 {
-		log.Debugf("No ROA found for %s", prefix)
-		return nil
+	routinatorLimit.acquire()
+	defer routinatorLimit.release()
+	resp, err := r.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("error contacting routinator: %s", err)
 	}
+	defer resp.Body.Close()
 
-	ta := make(map[string]bool)
-	asn := make(map[string]bool)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("routinator answered %s for %s", resp.Status, url)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding received data: %s", err)
+	}
+	return nil
+}
 
-	for _,r := range roaraw {
-		vrp := r.(map[string]interface{})
-		ta[vrp["ta"].(string)] = true
-		asn[vrp["asn"].(string)] = true
+// Lookup uses the select-prefix query of routinator
+func (r *RoutinatorSource) Lookup(prefix netip.Prefix) ([]VRP, error) {
+	var response routinatorResponse
+	if err := r.get(r.url+prefix.String(), &response); err != nil {
+		return nil, err
+	}
+	if response.Roas == nil {
+		return nil, fmt.Errorf("no roas in routinator answer for %s", prefix)
 	}
 
-	for t := range ta {
-		roa.Ta = append(roa.Ta, t) 
+	if response.Metadata.Generated > 0 {
+		r.mutex.Lock()
+		r.metadata.LastUpdate = time.Unix(response.Metadata.Generated, 0).UTC()
+		r.mutex.Unlock()
 	}
-	for a := range asn {
-		roa.Asn = append(roa.Asn, a)
+
+	result := make([]VRP, 0)
+	for _, roa := range response.Roas {
+		p, err := netip.ParsePrefix(roa.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		asn, err := parseASN(roa.ASN)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, VRP{Prefix: p, MaxLength: roa.MaxLength, ASN: asn, TA: roa.TA})
 	}
 
-	return
+	return result, nil
 }
 
-func ip2prefix(ipstr string) string {
-
-	ip,err := netip.ParseAddr(ipstr)
-	if err != nil {
-		log.Fatalf("Could not parse ip %s: %s", ipstr, err)
+// Validate asks routinator for the route origin validation state of prefix announced by asn
+func (r *RoutinatorSource) Validate(prefix netip.Prefix, asn uint32) (*Validity, error) {
+	var response routinatorValidity
+	if err := r.get(fmt.Sprintf("%s/api/v1/validity/AS%d/%s", r.base, asn, prefix), &response); err != nil {
+		return nil, err
 	}
 
-	mask := 24
-	if ip.Is6() {
-		mask = 64
-	} 
-	var prefix netip.Prefix
-	prefix,err = ip.Prefix(mask)
-	if err != nil {
-		log.Fatalf("Could mask ip %s mask %d: %s", ip, mask, err)
+	validity := &Validity{
+		Prefix: prefix.String(),
+		Origin: asn,
+		State:  response.ValidatedRoute.Validity.State,
+		Reason: response.ValidatedRoute.Validity.Reason,
+	}
+	switch validity.State {
+	case VALIDITY_VALID, VALIDITY_INVALID, VALIDITY_NOT_FOUND:
+	default:
+		return nil, fmt.Errorf("unknown validity state %q for AS%d %s", validity.State, asn, prefix)
 	}
-	return prefix.String()
+	return validity, nil
 }
@@ -0,0 +1,179 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoutinator serves handler and returns a source asking it
+func testRoutinator(t *testing.T, handler http.HandlerFunc) *RoutinatorSource {
+	t.Helper()
+	return testRoutinatorAt(t, "", handler)
+}
+
+// testRoutinatorAt serves handler and returns a source asking it under path
+func testRoutinatorAt(t *testing.T, path string, handler http.HandlerFunc) *RoutinatorSource {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	r, err := newRoutinatorSource(server.URL + path + "/json?select-prefix=")
+	if err != nil {
+		t.Fatalf("newRoutinatorSource: %s", err)
+	}
+	return r
+}
+
+func TestRoutinatorLookup(t *testing.T) {
+	r := testRoutinator(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/json" || req.URL.Query().Get("select-prefix") != "192.0.2.0/24" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(`{"metadata": {"generated": 1700000000, "generatedTime": "2023-11-14T22:13:20Z"},
+			"roas": [{"asn": "AS64496", "prefix": "192.0.2.0/24", "maxLength": 24, "ta": "ripe"},
+			         {"asn": "AS64497", "prefix": "192.0.0.0/16", "maxLength": 24, "ta": "arin"}]}`))
+	})
+
+	vrps, err := r.Lookup(netip.MustParsePrefix("192.0.2.0/24"))
+	if err != nil {
+		t.Fatalf("Lookup: %s", err)
+	}
+	want := []VRP{
+		{Prefix: netip.MustParsePrefix("192.0.2.0/24"), MaxLength: 24, ASN: 64496, TA: "ripe"},
+		{Prefix: netip.MustParsePrefix("192.0.0.0/16"), MaxLength: 24, ASN: 64497, TA: "arin"},
+	}
+	if len(vrps) != len(want) {
+		t.Fatalf("Lookup returned %v, want %v", vrps, want)
+	}
+	for i := range want {
+		if vrps[i] != want[i] {
+			t.Errorf("VRP %d is %v, want %v", i, vrps[i], want[i])
+		}
+	}
+	if got := r.Metadata().LastUpdate; !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("LastUpdate is %s, want generated time of the answer", got)
+	}
+}
+
+func TestRoutinatorLookupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"non-200", func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		}, "503"},
+		{"no roas", func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(`{"metadata": {"generated": 1700000000}}`))
+		}, "no roas"},
+		{"invalid json", func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(`{"roas": [`))
+		}, "decoding"},
+		{"invalid asn", func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(`{"roas": [{"asn": "ASxyz", "prefix": "192.0.2.0/24", "maxLength": 24, "ta": "ripe"}]}`))
+		}, "parse ASN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRoutinator(t, tt.handler)
+			_, err := r.Lookup(netip.MustParsePrefix("192.0.2.0/24"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Lookup error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutinatorTimeout(t *testing.T) {
+	done := make(chan struct{})
+	r := testRoutinator(t, func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-req.Context().Done():
+		}
+	})
+	defer close(done)
+	r.client.Timeout = 50 * time.Millisecond
+
+	_, err := r.Lookup(netip.MustParsePrefix("192.0.2.0/24"))
+	if err == nil || !strings.Contains(err.Error(), "error contacting routinator") {
+		t.Errorf("Lookup error %v, want timeout", err)
+	}
+}
+
+func TestRoutinatorValidate(t *testing.T) {
+	tests := []struct {
+		state   string
+		wantErr bool
+	}{
+		{VALIDITY_VALID, false},
+		{VALIDITY_INVALID, false},
+		{VALIDITY_NOT_FOUND, false},
+		{"bogus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			r := testRoutinator(t, func(w http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != "/api/v1/validity/AS64496/192.0.2.0/24" {
+					http.NotFound(w, req)
+					return
+				}
+				w.Write([]byte(`{"validated_route": {"route": {"origin_asn": "AS64496", "prefix": "192.0.2.0/24"},
+					"validity": {"state": "` + tt.state + `", "reason": "as", "description": ""}}}`))
+			})
+			validity, err := r.Validate(netip.MustParsePrefix("192.0.2.0/24"), 64496)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Validate returned %v, want error", validity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %s", err)
+			}
+			if validity.State != tt.state || validity.Origin != 64496 || validity.Reason != "as" {
+				t.Errorf("Validate returned %+v", validity)
+			}
+		})
+	}
+}
+
+// a routinator behind a reverse proxy keeps its path for the validity API
+func TestRoutinatorPathPrefix(t *testing.T) {
+	r := testRoutinatorAt(t, "/rpki", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/rpki/api/v1/validity/AS64496/192.0.2.0/24" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(`{"validated_route": {"route": {"origin_asn": "AS64496", "prefix": "192.0.2.0/24"},
+			"validity": {"state": "valid", "reason": "", "description": ""}}}`))
+	})
+	validity, err := r.Validate(netip.MustParsePrefix("192.0.2.0/24"), 64496)
+	if err != nil {
+		t.Fatalf("Validate: %s", err)
+	}
+	if validity.State != VALIDITY_VALID {
+		t.Errorf("Validate returned %+v", validity)
+	}
+}
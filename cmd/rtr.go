@@ -0,0 +1,315 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// RPKI to Router protocol (RFC 6810, RFC 8210, draft-ietf-sidrops-8210bis)
+const (
+	RTR_SERIAL_NOTIFY  uint8 = 0
+	RTR_SERIAL_QUERY   uint8 = 1
+	RTR_RESET_QUERY    uint8 = 2
+	RTR_CACHE_RESPONSE uint8 = 3
+	RTR_IPV4_PREFIX    uint8 = 4
+	RTR_IPV6_PREFIX    uint8 = 6
+	RTR_END_OF_DATA    uint8 = 7
+	RTR_CACHE_RESET    uint8 = 8
+	RTR_ROUTER_KEY     uint8 = 9
+	RTR_ERROR_REPORT   uint8 = 10
+	RTR_ASPA           uint8 = 11
+)
+
+// highest protocol version we speak, version 2 adds ASPA
+const RTR_MAX_VERSION uint8 = 2
+
+// error code for an unsupported protocol version
+const RTR_ERR_UNSUPPORTED_VERSION uint16 = 4
+
+// RTR_TIMEOUT limits how long we wait for the cache (seconds)
+const RTR_TIMEOUT = 60
+
+// largest PDU we accept from a cache
+const RTR_MAX_PDU = 64 * 1024
+
+type rtrPDU struct {
+	version uint8
+	ptype   uint8
+	session uint16
+	body    []byte
+}
+
+// RTRClient keeps the state of a synchronisation with an RTR cache
+type RTRClient struct {
+	addr    string
+	version uint8
+	session uint16
+	serial  uint32
+	synced  bool
+
+	table *VRPTable
+	conn  net.Conn
+	rd    *bufio.Reader
+}
+
+func newRTRClient(addr string) *RTRClient {
+	return &RTRClient{addr: addr, version: RTR_MAX_VERSION, table: newVRPTable()}
+}
+
+// rtrSync connects to an RTR cache and returns its full VRP set
+func rtrSync(addr string) (*VRPTable, error) {
+	client := newRTRClient(addr)
+	defer client.close()
+	if err := client.sync(); err != nil {
+		return nil, fmt.Errorf("error synchronising with RTR cache %s: %w", addr, err)
+	}
+	return client.table, nil
+}
+
+// sync fetches the VRP set. The first call sends a reset query, later
+// calls send a serial query and apply the received changes.
+func (c *RTRClient) sync() error {
+	for {
+		if c.conn == nil {
+			if err := c.connect(); err != nil {
+				return err
+			}
+		}
+		err := c.query()
+		if err == nil {
+			return nil
+		}
+		if verr, ok := err.(*rtrVersionError); ok && verr.version < c.version {
+			// try again with the version offered by the cache
+			log.Debugf("RTR cache %s does not support version %d, trying %d", c.addr, c.version, verr.version)
+			c.version = verr.version
+			c.close()
+			continue
+		}
+		c.close()
+		return err
+	}
+}
+
+func (c *RTRClient) connect() error {
+	log.Debugf("Connecting to RTR cache %s", c.addr)
+	conn, err := net.DialTimeout("tcp", c.addr, RTR_TIMEOUT*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.rd = bufio.NewReader(conn)
+	return nil
+}
+
+func (c *RTRClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *RTRClient) query() error {
+	if c.synced {
+		log.Debugf("RTR serial query session %d serial %d", c.session, c.serial)
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint32(body, c.serial)
+		if err := c.write(RTR_SERIAL_QUERY, c.session, body); err != nil {
+			return err
+		}
+	} else {
+		log.Debugf("RTR reset query version %d", c.version)
+		if err := c.write(RTR_RESET_QUERY, 0, nil); err != nil {
+			return err
+		}
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(RTR_TIMEOUT * time.Second))
+	for {
+		pdu, err := c.read()
+		if err != nil {
+			return err
+		}
+		switch pdu.ptype {
+		case RTR_SERIAL_NOTIFY:
+			// we are already asking
+		case RTR_CACHE_RESPONSE:
+			if !c.synced {
+				c.session = pdu.session
+				c.table = newVRPTable()
+				c.table.name = "rtr " + c.addr
+			} else if pdu.session != c.session {
+				return fmt.Errorf("session changed from %d to %d", c.session, pdu.session)
+			}
+		case RTR_IPV4_PREFIX, RTR_IPV6_PREFIX:
+			if err := c.prefix(pdu); err != nil {
+				return err
+			}
+		case RTR_ROUTER_KEY:
+			// BGPsec router keys are not used
+		case RTR_ASPA:
+			if err := c.aspa(pdu); err != nil {
+				return err
+			}
+		case RTR_END_OF_DATA:
+			if len(pdu.body) < 4 {
+				return fmt.Errorf("short end of data PDU")
+			}
+			c.serial = binary.BigEndian.Uint32(pdu.body)
+			c.synced = true
+			c.table.serial = c.serial
+			c.table.generated = time.Now().UTC()
+			log.Debugf("RTR end of data session %d serial %d: %d VRPs, %d ASPAs", c.session, c.serial, c.table.count, len(c.table.aspas))
+			return nil
+		case RTR_CACHE_RESET:
+			log.Debugf("RTR cache reset, sending reset query")
+			c.synced = false
+			if err := c.write(RTR_RESET_QUERY, 0, nil); err != nil {
+				return err
+			}
+		case RTR_ERROR_REPORT:
+			return c.errorReport(pdu)
+		default:
+			return fmt.Errorf("unexpected PDU type %d", pdu.ptype)
+		}
+	}
+}
+
+func (c *RTRClient) write(ptype uint8, session uint16, body []byte) error {
+	pdu := make([]byte, 8+len(body))
+	pdu[0] = c.version
+	pdu[1] = ptype
+	binary.BigEndian.PutUint16(pdu[2:4], session)
+	binary.BigEndian.PutUint32(pdu[4:8], uint32(len(pdu)))
+	copy(pdu[8:], body)
+	c.conn.SetWriteDeadline(time.Now().Add(RTR_TIMEOUT * time.Second))
+	_, err := c.conn.Write(pdu)
+	return err
+}
+
+func (c *RTRClient) read() (*rtrPDU, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.rd, header); err != nil {
+		return nil, err
+	}
+	pdu := &rtrPDU{version: header[0], ptype: header[1], session: binary.BigEndian.Uint16(header[2:4])}
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length < 8 || length > RTR_MAX_PDU {
+		return nil, fmt.Errorf("invalid PDU length %d", length)
+	}
+	pdu.body = make([]byte, length-8)
+	if _, err := io.ReadFull(c.rd, pdu.body); err != nil {
+		return nil, err
+	}
+	if pdu.version != c.version && pdu.ptype != RTR_ERROR_REPORT {
+		return nil, fmt.Errorf("cache answered with version %d, expected %d", pdu.version, c.version)
+	}
+	return pdu, nil
+}
+
+// prefix handles IPv4 and IPv6 prefix PDUs
+//
+//	flags(1) prefix length(1) max length(1) zero(1) prefix(4 or 16) asn(4)
+func (c *RTRClient) prefix(pdu *rtrPDU) error {
+	addrlen := 4
+	if pdu.ptype == RTR_IPV6_PREFIX {
+		addrlen = 16
+	}
+	if len(pdu.body) != 4+addrlen+4 {
+		return fmt.Errorf("invalid prefix PDU length %d", len(pdu.body)+8)
+	}
+	announce := pdu.body[0]&1 == 1
+	addr, _ := netip.AddrFromSlice(pdu.body[4 : 4+addrlen])
+	prefix, err := addr.Prefix(int(pdu.body[1]))
+	if err != nil {
+		return err
+	}
+	vrp := VRP{
+		Prefix:    prefix,
+		MaxLength: int(pdu.body[2]),
+		ASN:       binary.BigEndian.Uint32(pdu.body[4+addrlen:]),
+	}
+	if announce {
+		c.table.add(vrp)
+	} else {
+		c.table.remove(vrp)
+	}
+	return nil
+}
+
+// aspa handles ASPA PDUs (version 2 only)
+//
+//	flags(1) zero(1) length(4) customer asn(4) provider asns(4 each)
+//
+// the flags and zero octets are carried in the session field of the header
+func (c *RTRClient) aspa(pdu *rtrPDU) error {
+	if len(pdu.body) < 4 || len(pdu.body)%4 != 0 {
+		return fmt.Errorf("invalid ASPA PDU length %d", len(pdu.body)+8)
+	}
+	announce := (pdu.session>>8)&1 == 1
+	customer := binary.BigEndian.Uint32(pdu.body)
+	if !announce {
+		delete(c.table.aspas, customer)
+		return nil
+	}
+	providers := make([]uint32, 0)
+	for i := 4; i < len(pdu.body); i += 4 {
+		providers = append(providers, binary.BigEndian.Uint32(pdu.body[i:]))
+	}
+	c.table.aspas[customer] = providers
+	return nil
+}
+
+// rtrVersionError is returned if the cache does not speak our protocol version
+type rtrVersionError struct {
+	version uint8
+}
+
+func (e *rtrVersionError) Error() string {
+	return fmt.Sprintf("unsupported protocol version, cache offers version %d", e.version)
+}
+
+// errorReport decodes an error report PDU
+//
+//	encapsulated length(4) encapsulated PDU text length(4) text
+func (c *RTRClient) errorReport(pdu *rtrPDU) error {
+	// lengths are checked as uint64, in uint32 a large length wraps around
+	text := ""
+	size := uint64(len(pdu.body))
+	if size >= 4 {
+		encaplen := uint64(binary.BigEndian.Uint32(pdu.body))
+		if size >= 8+encaplen {
+			textlen := uint64(binary.BigEndian.Uint32(pdu.body[4+encaplen:]))
+			if size >= 8+encaplen+textlen {
+				text = string(pdu.body[8+encaplen : 8+encaplen+textlen])
+			}
+		}
+	}
+	if pdu.session == RTR_ERR_UNSUPPORTED_VERSION && pdu.version < c.version {
+		return &rtrVersionError{version: pdu.version}
+	}
+	return fmt.Errorf("cache sent error %d: %s", pdu.session, text)
+}
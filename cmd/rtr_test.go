@@ -0,0 +1,57 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// errorBody builds the body of an error report PDU
+func errorBody(encaplen uint32, encap []byte, textlen uint32, text string) []byte {
+	body := binary.BigEndian.AppendUint32(nil, encaplen)
+	body = append(body, encap...)
+	body = binary.BigEndian.AppendUint32(body, textlen)
+	return append(body, text...)
+}
+
+func TestErrorReport(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want string
+	}{
+		{"text", errorBody(0, nil, 4, "oops"), "cache sent error 2: oops"},
+		{"encapsulated pdu", errorBody(2, []byte{1, 2}, 4, "oops"), "cache sent error 2: oops"},
+		{"empty", nil, "cache sent error 2: "},
+		{"short text", errorBody(0, nil, 10, "oops"), "cache sent error 2: "},
+		{"encapsulated length too long", errorBody(100, nil, 4, "oops"), "cache sent error 2: "},
+		// 8+encaplen wraps to 1 in uint32
+		{"encapsulated length wraps", errorBody(0xFFFFFFF9, nil, 4, "oops"), "cache sent error 2: "},
+		// 8+encaplen+textlen wraps to 4 in uint32
+		{"text length wraps", errorBody(0, nil, 0xFFFFFFFC, "oops"), "cache sent error 2: "},
+	}
+	client := newRTRClient("127.0.0.1:323")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.errorReport(&rtrPDU{version: client.version, session: 2, body: tt.body})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("errorReport = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -18,8 +18,11 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"sort"
 	"time"
 	"strings"
+	"sync"
 
 	"io/ioutil"
 
@@ -31,19 +34,131 @@ import (
 )
 
 type RPKIstat struct {
-	Domain string
-	Date time.Time
-	Names int
-	NamesFull int
-	NamesPartial int
-	IPv4 int
-	IPv4roas int
-	IPv6 int
-	IPv6roas int
-	TAs4 int
-	TAs6 int
-	AS4 int
-	AS6 int
+	Domain string `json:"domain"`
+	Date time.Time `json:"date"`
+	Names int `json:"names"`
+	NamesFull int `json:"names_full"`
+	NamesPartial int `json:"names_partial"`
+	IPv4 int `json:"ipv4"`
+	IPv4roas int `json:"ipv4_roas"`
+	IPv6 int `json:"ipv6"`
+	IPv6roas int `json:"ipv6_roas"`
+	TAs4 int `json:"tas4"`
+	TAs6 int `json:"tas6"`
+	AS4 int `json:"as4"`
+	AS6 int `json:"as6"`
+	// route origin validation, only addresses with a known announcement are counted
+	IPv4valid int `json:"ipv4_valid"`
+	IPv4invalidAS int `json:"ipv4_invalid_as"`
+	IPv4invalidLength int `json:"ipv4_invalid_length"`
+	IPv4notFound int `json:"ipv4_not_found"`
+	IPv6valid int `json:"ipv6_valid"`
+	IPv6invalidAS int `json:"ipv6_invalid_as"`
+	IPv6invalidLength int `json:"ipv6_invalid_length"`
+	IPv6notFound int `json:"ipv6_not_found"`
+	// addresses whose announced prefix is longer than the max length of all ROAs
+	IPv4maxLengthInvalid int `json:"ipv4_maxlength_invalid"`
+	IPv6maxLengthInvalid int `json:"ipv6_maxlength_invalid"`
+	// addresses covered by a ROA with max length longer than its prefix
+	IPv4maxLengthPermissive int `json:"ipv4_maxlength_permissive"`
+	IPv6maxLengthPermissive int `json:"ipv6_maxlength_permissive"`
+	// addresses for which the ROA source could not answer
+	IPv4unknown int `json:"ipv4_unknown"`
+	IPv6unknown int `json:"ipv6_unknown"`
+	// failed lookups per category
+	ErrServfail int `json:"errors_servfail"`
+	ErrTimeout int `json:"errors_timeout"`
+	ErrNxdomain int `json:"errors_nxdomain"`
+	ErrDNS int `json:"errors_dns"`
+	ErrROA int `json:"errors_roa"`
+	// ok, partial or failed, see the reason codes
+	Status string `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+	// NS set the statistic is based on, child or parent
+	NSSet string `json:"ns_set"`
+	// comparison with the delegation in the parent, only with --delegation
+	ParentOnly int `json:"parent_only"`
+	ChildOnly int `json:"child_only"`
+	ParentCoverage string `json:"parent_coverage,omitempty"`
+	ChildCoverage string `json:"child_coverage,omitempty"`
+	// names with glue in the parent and names whose glue differs from their resolved addresses
+	GlueNames int `json:"glue_names"`
+	GlueMismatch int `json:"glue_mismatch"`
+	// addresses answering authoritatively and those of them with ROAs, only with --probe
+	Probed bool `json:"probed"`
+	IPv4serving int `json:"ipv4_serving"`
+	IPv4servingROAs int `json:"ipv4_serving_roas"`
+	IPv6serving int `json:"ipv6_serving"`
+	IPv6servingROAs int `json:"ipv6_serving_roas"`
+	// number of different SOA serials in the answers
+	Serials int `json:"soa_serials"`
+	// names the resolvers answered differently, only in compare mode
+	ResolversCompared bool `json:"resolvers_compared"`
+	ResolverDifferences int `json:"resolver_differences"`
+}
+
+// countError counts a failed lookup in its category
+func (s *RPKIstat) countError(category string) {
+	switch category {
+	case ERROR_SERVFAIL:
+		s.ErrServfail++
+	case ERROR_TIMEOUT:
+		s.ErrTimeout++
+	case ERROR_NXDOMAIN:
+		s.ErrNxdomain++
+	case ERROR_ROA:
+		s.ErrROA++
+	default:
+		s.ErrDNS++
+	}
+}
+
+// classify sets status and reasons from the lookup errors of a measurement.
+// A measurement without nameservers or addresses has failed, its counters
+// are no data and must not be taken as zero.
+func (s *RPKIstat) classify(nsErr error, nsErrors map[string]string) {
+	s.Reasons = make([]string, 0)
+	failed := false
+	if nsErr != nil {
+		s.Reasons = append(s.Reasons, REASON_NS+errorCategory(nsErr))
+		failed = true
+	} else if s.Names == 0 {
+		s.Reasons = append(s.Reasons, REASON_NO_NAMESERVERS)
+		failed = true
+	}
+
+	categories := make([]string, 0)
+	for _, category := range nsErrors {
+		categories = append(categories, category)
+	}
+	categories = unique(categories)
+	sort.Strings(categories)
+	for _, category := range categories {
+		s.Reasons = append(s.Reasons, REASON_ADDRESS+category)
+	}
+	if len(categories) > 0 && s.IPv4+s.IPv6 == 0 {
+		failed = true
+	}
+	if s.IPv4unknown+s.IPv6unknown > 0 {
+		s.Reasons = append(s.Reasons, REASON_ROA_UNAVAILABLE)
+	}
+
+	switch {
+	case failed:
+		s.Status = STATUS_FAILED
+	case len(s.Reasons) > 0:
+		s.Status = STATUS_PARTIAL
+	default:
+		s.Status = STATUS_OK
+	}
+}
+
+// addReason adds a reason to a classified measurement, an ok measurement becomes partial
+func (s *RPKIstat) addReason(reason string) {
+	s.Reasons = append(s.Reasons, reason)
+	if s.Status == STATUS_OK {
+		s.Status = STATUS_PARTIAL
+	}
 }
 
 // runCmd represents the run command
@@ -51,6 +166,7 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Get RPKI statistics",
 	Long: `Get RPKI statistics for a single domain name or a list of domain names and optionally save to MariaDB`,
+	PreRun: bindFlags,
 	Run: execRun,
 }
 
@@ -65,31 +181,33 @@ func init() {
 	runCmd.Flags().StringP(DOMAIN, DOMAIN_SHORT, "", "domain name")
 	runCmd.Flags().StringP(DOMAIN_FILE, DOMAIN_FILE_SHORT, "", "file with a list of domain names")
 	runCmd.Flags().StringP(ROUTINATOR, ROUTINATOR_SHORT, "", "address (including port) of the routinator instance to use")
-	runCmd.Flags().StringP(RESOLVER, RESOLVER_SHORT, "", "address of the resolver to use")
-
-	// Use flags for viper values
-	viper.BindPFlags(runCmd.Flags())
+	runCmd.Flags().StringSliceP(RESOLVER, RESOLVER_SHORT, nil, "resolvers to use, [udp://|tcp://]ip[:port] (repeat or comma separated, default transport tcp)")
+	runCmd.Flags().String(RESOLVER_MODE, RESOLVER_ROUNDROBIN, "use of several resolvers: roundrobin, failover or compare (compare the answers of all resolvers)")
+	runCmd.Flags().String(VRPS, "", "VRP export (Routinator json, jsonext or csv, rpki-client json) used instead of routinator")
+	runCmd.Flags().String(RTR, "", "address (host:port) of an RTR cache used instead of routinator")
+	runCmd.Flags().StringP(PFX2AS, PFX2AS_SHORT, "", "prefix to origin table (pfx2as or bgpdump -m output) used for prefix lookup and route origin validation")
+	runCmd.Flags().StringP(OUTPUT, OUTPUT_SHORT, "", "output format: text, json, ndjson or csv (default text for a single domain, none for a domain list)")
+	runCmd.Flags().String(OUTPUT_FILE, "", "write output to file instead of stdout")
+	runCmd.Flags().Bool(DETAIL, false, "print (and save) results per nameserver and address")
+	runCmd.Flags().IntP(WORKERS, WORKERS_SHORT, 10, "number of domains processed concurrently")
+	runCmd.Flags().Int(DNS_LIMIT, 50, "maximum number of DNS queries in flight")
+	runCmd.Flags().Int(ROUTINATOR_LIMIT, 10, "maximum number of Routinator requests in flight")
+	runCmd.Flags().String(LOCK_FILE, defaultLockFile(), "lock file preventing overlapping domain list runs")
+	runCmd.Flags().Int(BATCH, 100, "number of domains saved to the database per transaction")
+	runCmd.Flags().Int64(RESUME, 0, "continue the run with this id after its last saved domain")
+	runCmd.Flags().Bool(DELEGATION, false, "ask the parent zone for the delegation and compare it with the NS set of the child")
+	runCmd.Flags().String(NS_SET, NS_SET_CHILD, "NS set the statistics are based on: child or parent (implies --delegation)")
+	runCmd.Flags().Bool(PROBE, false, "send an SOA query to every nameserver address and record the answers")
+	runCmd.Flags().StringSlice(ROOTS, nil, "addresses of the root servers to start the delegation lookup from (default IANA root servers)")
 }
 
 func execRun(cmd *cobra.Command, args []string) {
 
-	if viper.GetString(ROUTINATOR) == "" {
-		cmd.Help();
-		log.Fatal("Routinator must be given.")
-	} else {
-		log.Debugf("Routinator: %s", viper.GetString(ROUTINATOR))
-	}
-
-	if viper.GetString(RESOLVER) == "" {
-		cmd.Help();
-		log.Fatal("Resolver must be given.")
-	} else {
-		log.Debugf("Resolver: %s", viper.GetString(RESOLVER))
-	}
+	roaSource := prepareRun(cmd)
 
-	if viper.GetString(DOMAIN) == "" && viper.GetString(DOMAIN_FILE) == "" {
+	if viper.GetString(DOMAIN) == "" && viper.GetString(DOMAIN_FILE) == "" && viper.GetInt64(RESUME) == 0 {
 		cmd.Help();
-		log.Fatal("Domain or domain list must be given.")
+		log.Fatal("Domain, domain list or run to resume must be given.")
 	} else {
 		if viper.GetString(DOMAIN) != "" {
 			log.Debugf("Domain: %s", viper.GetString(DOMAIN))
@@ -105,45 +223,294 @@ func execRun(cmd *cobra.Command, args []string) {
 		log.Debugf("DBCredentials: %s", viper.GetString(DBCREDENTIALS))
 	}
 	
+	format := viper.GetString(OUTPUT)
+	switch format {
+	case "", OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_NDJSON, OUTPUT_CSV:
+	default:
+		cmd.Help();
+		log.Fatalf("Unknown output format %s", format)
+	}
+
 	// single domain gets only printed on command line, not saved to database
 	if viper.GetString(DOMAIN) != "" {
 		domain := viper.GetString(DOMAIN)
 		log.Debugf("Single domain statistics (no db): %s", domain)
-		rpkistat := domainStat(domain)
-		fmt.Printf("Domain    %-15s\n", rpkistat.Domain)
-		fmt.Printf("Names     %2d\n",   rpkistat.Names)
-		fmt.Printf("  Full    %2d\n",   rpkistat.NamesFull)
-		fmt.Printf("  Partial %2d\n",   rpkistat.NamesPartial)
-		fmt.Printf("IPv4      %2d\n",   rpkistat.IPv4)
-		fmt.Printf("  ROAs    %2d\n",   rpkistat.IPv4roas)
-		fmt.Printf("  TA      %2d\n",   rpkistat.TAs4)
-		fmt.Printf("  AS ROAs %2d\n",   rpkistat.AS4)
-		fmt.Printf("IPv6      %2d\n",   rpkistat.IPv6)
-		fmt.Printf("  ROAs    %2d\n",   rpkistat.IPv6roas)
-		fmt.Printf("  TA      %2d\n",   rpkistat.TAs6)
-		fmt.Printf("  AS ROAs %2d\n",   rpkistat.AS6)
+		result, err := domainStat(domain, roaSource)
+		if err != nil {
+			log.Errorf("Could not measure %s: %s", domain, err)
+		}
+		if format == "" {
+			format = OUTPUT_TEXT
+		}
+		writeOutput(format, []*DomainResult{result})
 		return
 	}
 
 	domainfile := viper.GetString(DOMAIN_FILE)
 	log.Debugf("Using domain file: %s", domainfile)
-	rpkistats := handleDomainList(domainfile)
 
-	if viper.GetString(DBCREDENTIALS) == "" {
-		// do not save to database
-		return
+	lock, err := lockRun(viper.GetString(LOCK_FILE))
+	if err != nil {
+		log.Fatalf("Could not lock %s: %s", viper.GetString(LOCK_FILE), err)
+	}
+	defer lock.unlock()
+
+	// open database before the run to fail early
+	var db *Storage
+	if viper.GetString(DBCREDENTIALS) != "" {
+		db, err = openDB()
+		if err != nil {
+			log.Fatalf("Could not open database: %s", err)
+		}
+		defer db.Close()
+		if err = interruptedRuns2db(db); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	var run *Run
+	if viper.GetInt64(RESUME) != 0 {
+		if db == nil {
+			log.Fatal("Resuming a run needs DB credentials.")
+		}
+		run, err = resumeRun(db, viper.GetInt64(RESUME), domainfile)
+		if err != nil {
+			log.Fatalf("Could not resume run %d: %s", viper.GetInt64(RESUME), err)
+		}
+	} else {
+		run, err = newRun(resolverList(), roaSource, domainfile)
+		if err != nil {
+			log.Fatalf("Error reading Domain file %s: %s", domainfile, err)
+		}
+		if db != nil {
+			if err = startRun2db(db, run); err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+	}
+
+	results, err := measureRun(db, run, roaSource)
+	if err != nil {
+		log.Fatalf("Run failed: %s", err)
+	}
+	log.Debugf("Run finished after %s, DNS errors %d, ROA errors %d", run.Finished.Sub(run.Started), run.DNSErrors, run.ROAErrors)
+
+	// domain lists are only printed if asked for
+	if format == "" && viper.GetBool(DETAIL) {
+		format = OUTPUT_TEXT
+	}
+	if format != "" {
+		writeOutput(format, results)
+	}
+}
+
+// measureRun runs the domains of run after its checkpoint. With a database
+// results are saved while the run goes on and the outcome of the run is recorded.
+func measureRun(db *Storage, run *Run, src ROASource) ([]*DomainResult, error) {
+	domains, err := readDomainList(run.InputFile)
+	if err == nil && run.Done > len(domains) {
+		err = fmt.Errorf("checkpoint %d is beyond the %d domains of %s", run.Done, len(domains), run.InputFile)
 	}
 
-	// save results to database
-	db := openDB()
-	rpki2db(db, rpkistats)
+	var results []*DomainResult
+	if err == nil {
+		var save func([]*DomainResult) error
+		if db != nil {
+			save = func(batch []*DomainResult) error {
+				return rpki2db(db, run, batch, viper.GetBool(DETAIL))
+			}
+		}
+		results, err = handleDomainList(domains[run.Done:], src, save)
+	}
+	run.finish(src, len(results))
+
+	if db == nil {
+		// with a database rpki2db counts the errors of saved results
+		run.addErrors(results)
+		return results, err
+	}
+	if err != nil {
+		if ferr := failRun2db(db, run, err); ferr != nil {
+			log.Errorf("Could not record failure of run %d: %s", run.ID, ferr)
+		}
+		return nil, err
+	}
+	return results, finishRun2db(db, run)
 }
 
-func handleDomainList(filename string) (stats []*RPKIstat) {
-	stats = make([]*RPKIstat, 0)
+// resumeRun loads an unfinished run and checks that the domain list is unchanged
+func resumeRun(db *Storage, id int64, domainfile string) (*Run, error) {
+	run, err := loadRun2db(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status == RUN_SUCCESS {
+		return nil, fmt.Errorf("run %d is already finished", id)
+	}
+	if domainfile == "" {
+		domainfile = run.InputFile
+	}
+	hash, err := fileHash(domainfile)
+	if err != nil {
+		return nil, err
+	}
+	if hash != run.InputHash {
+		return nil, fmt.Errorf("domain file %s changed since run %d started", domainfile, id)
+	}
+	run.InputFile = domainfile
+	if run.Resolver != resolverList() {
+		log.Warnf("Run %d was started with resolver %s, continuing with %s", id, run.Resolver, resolverList())
+	}
+
+	if err = resumeRun2db(db, run); err != nil {
+		return nil, err
+	}
+	log.Infof("Resuming run %d after %d domains", id, run.Done)
+	return run, nil
+}
+
+// prepareRun checks resolver and ROA source, loads the origin table and
+// sets up the limiters
+func prepareRun(cmd *cobra.Command) ROASource {
+	roaSource, err := selectROASource()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if roaSource == nil {
+		cmd.Help();
+		log.Fatal("Routinator, RTR cache or VRP file must be given.")
+	}
+
+	if len(viper.GetStringSlice(RESOLVER)) == 0 {
+		cmd.Help();
+		log.Fatal("Resolver must be given.")
+	} else {
+		log.Debugf("Resolver: %s", resolverList())
+	}
+	resolvers, err := getResolvers()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	switch viper.GetString(RESOLVER_MODE) {
+	case RESOLVER_ROUNDROBIN, RESOLVER_FAILOVER:
+	case RESOLVER_COMPARE:
+		if len(resolvers) < 2 {
+			log.Fatal("At least two resolvers must be given to compare them.")
+		}
+	default:
+		cmd.Help();
+		log.Fatalf("Unknown resolver mode %s", viper.GetString(RESOLVER_MODE))
+	}
+
+	switch viper.GetString(NS_SET) {
+	case NS_SET_CHILD, NS_SET_PARENT:
+	default:
+		cmd.Help();
+		log.Fatalf("Unknown NS set %s", viper.GetString(NS_SET))
+	}
+	for _, root := range viper.GetStringSlice(ROOTS) {
+		if net.ParseIP(root) == nil {
+			log.Fatalf("Could not parse root server address %s", root)
+		}
+	}
+
+	if viper.GetString(PFX2AS) != "" {
+		log.Debugf("Prefix to origin table: %s", viper.GetString(PFX2AS))
+		table, err := loadOriginTable(viper.GetString(PFX2AS))
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		origins.Store(table)
+	}
+
+	log.Debugf("Workers: %d, DNS limit: %d, Routinator limit: %d", viper.GetInt(WORKERS), viper.GetInt(DNS_LIMIT), viper.GetInt(ROUTINATOR_LIMIT))
+	dnsLimit = newLimiter(viper.GetInt(DNS_LIMIT))
+	routinatorLimit = newLimiter(viper.GetInt(ROUTINATOR_LIMIT))
+
+	return roaSource
+}
+
+// handleDomainList runs all domains. If save is given, results are passed
+// to it in the order of the domains, in batches as soon as a batch is complete.
+// The first error of save stops the run.
+func handleDomainList(domains []string, src ROASource, save func([]*DomainResult) error) (results []*DomainResult, err error) {
+	results = make([]*DomainResult, len(domains))
+
+	workers := viper.GetInt(WORKERS)
+	if workers < 1 {
+		workers = 1
+	}
+	log.Debugf("Running %d domains with %d workers", len(domains), workers)
+
+	// every worker writes its result to the index of the domain,
+	// this keeps the results in the order of the domain file
+	jobs := make(chan int)
+	finished := make(chan int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				log.Debugf("Running domain: %s", domains[i])
+				var err error
+				results[i], err = domainStat(domains[i], src)
+				if err != nil {
+					log.Debugf("Could not measure %s: %s", domains[i], err)
+				}
+				finished <- i
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for i := range domains {
+			select {
+			case jobs <- i:
+			case <-stop:
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(finished)
+	}()
+
+	batch := viper.GetInt(BATCH)
+	if batch < 1 {
+		batch = 1
+	}
+
+	// next is the first domain not finished, all domains before saved are saved
+	done := make([]bool, len(domains))
+	next, saved := 0, 0
+	for i := range finished {
+		done[i] = true
+		for next < len(domains) && done[next] {
+			next++
+		}
+		if err == nil && save != nil && next > saved && (next-saved >= batch || next == len(domains)) {
+			if err = save(results[saved:next]); err != nil {
+				// let the workers finish their domains
+				close(stop)
+				continue
+			}
+			saved = next
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func readDomainList(filename string) (domains []string, err error) {
+	domains = make([]string, 0)
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatalf("Error reading Domain file %s: %s", filename, err)
+		return nil, fmt.Errorf("error reading domain file %s: %w", filename, err)
 	}
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
@@ -160,60 +527,229 @@ func handleDomainList(filename string) (stats []*RPKIstat) {
 		}
 		
 		// now we should have a valid domain 
-		domain := strings.ToLower(line)
-
-		log.Debugf("Running domain: %s", domain)
-		stats = append(stats, domainStat(domain))
+		domains = append(domains, strings.ToLower(line))
 	}
 	return
 }
 
-func domainStat(domain string) (stat *RPKIstat) {
-	stat = &RPKIstat{Domain: domain, Date: time.Now()}
+// domainStat measures a domain. Failed lookups are counted in the result,
+// the returned error is set if the nameservers of the domain are unknown.
+// In compare mode the answers of all resolvers are compared as well.
+func domainStat(domain string, src ROASource) (*DomainResult, error) {
+	result, err := measureDomain(domain, src)
+	if viper.GetString(RESOLVER_MODE) == RESOLVER_COMPARE {
+		result.ResolverDifferences = compareResolvers(domain)
+		result.Stat.ResolversCompared = true
+		result.Stat.ResolverDifferences = len(result.ResolverDifferences)
+		if result.Stat.ResolverDifferences > 0 {
+			result.Stat.addReason(REASON_RESOLVERS_DIFFER)
+		}
+	}
+	return result, err
+}
 
-	nameservers := getNS(domain)
+// measureDomain measures the RPKI coverage of the nameservers of domain.
+// With --delegation the NS sets of parent and child are both measured and
+// --nsset chooses the one the statistic is based on.
+func measureDomain(domain string, src ROASource) (*DomainResult, error) {
+	date := time.Now()
+	if !delegationEnabled() {
+		nameservers, nsErr := getNS(domain)
+		l := lookupNameservers(nameservers, nil, src)
+		if viper.GetBool(PROBE) {
+			probeAddresses(domain, l)
+		}
+		result := measureNameservers(domain, date, nameservers, nsErr, l)
+		result.Stat.NSSet = NS_SET_CHILD
+		return result, nsErr
+	}
 
-	name2ip4 := make(map[string][]string, 0)
-	name2ip6 := make(map[string][]string, 0)
+	delegation, parentErr := getDelegation(domain)
+	if parentErr != nil {
+		log.Errorf("Delegation error for %s: %s", domain, parentErr)
+	}
+	childNS, childErr := getNS(domain)
+	for i := range childNS {
+		childNS[i] = strings.ToLower(childNS[i])
+	}
+	childNS = unique(childNS)
 
-	ip4list := make([]string, 0)
-	ip6list := make([]string, 0)
+	// addresses of names in both sets are looked up once,
+	// coverage is measured on the union of glue and resolved addresses
+	l := lookupNameservers(unique(append(append([]string{}, delegation.Nameservers...), childNS...)), delegation.Glue, src)
+	if viper.GetBool(PROBE) {
+		probeAddresses(domain, l)
+	}
+	parent := measureNameservers(domain, date, delegation.Nameservers, parentErr, l)
+	child := measureNameservers(domain, date, childNS, childErr, l)
+
+	result, other, err, otherReason := child, parent, childErr, REASON_PARENT_FAILED
+	if viper.GetString(NS_SET) == NS_SET_PARENT {
+		result, other, err, otherReason = parent, child, parentErr, REASON_CHILD_FAILED
+	}
+	result.Stat.NSSet = viper.GetString(NS_SET)
+	result.Delegation = compareDelegation(delegation, childNS)
+	result.Stat.ParentOnly = len(result.Delegation.ParentOnly)
+	result.Stat.ChildOnly = len(result.Delegation.ChildOnly)
+	result.Stat.ParentCoverage = measuredCoverage(parent.Stat)
+	result.Stat.ChildCoverage = measuredCoverage(child.Stat)
+	if other.Stat.failed() {
+		result.Stat.addReason(otherReason)
+	}
+	return result, err
+}
+
+// lookups holds the addresses of nameservers and what the ROA source knows about them
+type lookups struct {
+	name2ip4 map[string][]string
+	name2ip6 map[string][]string
+	// glue of the parent and resolved addresses per name
+	glue     map[string][]string
+	resolved map[string]map[string]bool
+	// categories of failed address lookups per name
+	nameErrors map[string][]string
+	roas       map[string]*ROA
+	validities map[string]*Validity
+	// number of failed ROA source lookups per address
+	unknown map[string]int
+	// addresses whose announcement could not be validated
+	validityErrors map[string]bool
+	// SOA answers per address, nil without --probe
+	probes map[string]*Probe
+}
 
-	for _,ns := range nameservers {
-		name2ip4[ns] = getIP4(ns)
-		name2ip6[ns] = getIP6(ns)
+// lookupNameservers resolves the addresses of nameservers, adds the glue
+// (if given) and looks up all addresses in the ROA source
+func lookupNameservers(nameservers []string, glue map[string][]string, src ROASource) *lookups {
+	l := &lookups{
+		name2ip4:   make(map[string][]string),
+		name2ip6:   make(map[string][]string),
+		glue:       glue,
+		resolved:   make(map[string]map[string]bool),
+		nameErrors: make(map[string][]string),
+		roas:       make(map[string]*ROA),
+		validities: make(map[string]*Validity),
+		unknown:    make(map[string]int),
+
+		validityErrors: make(map[string]bool),
 	}
 
-	ip4roas := make(map[string]*ROA, 0)
-	ip6roas := make(map[string]*ROA, 0)
-	for ns := range name2ip4 {
-		for _,ip4 := range name2ip4[ns] {
-			ip4list = append(ip4list, ip4)
-			if _,ok:=ip4roas[ip4]; ok {
-				// already done
+	for _, ns := range nameservers {
+		if _, ok := l.name2ip4[ns]; ok {
+			// already done
+			continue
+		}
+		var err4, err6 error
+		l.name2ip4[ns], err4 = getIP4(ns)
+		l.name2ip6[ns], err6 = getIP6(ns)
+		for _, err := range []error{err4, err6} {
+			if err != nil {
+				l.nameErrors[ns] = append(l.nameErrors[ns], errorCategory(err))
+			}
+		}
+
+		l.resolved[ns] = make(map[string]bool)
+		for _, ip := range append(append([]string{}, l.name2ip4[ns]...), l.name2ip6[ns]...) {
+			l.resolved[ns][ip] = true
+		}
+		for _, ip := range l.glue[ns] {
+			if l.resolved[ns][ip] {
 				continue
 			}
-			roa := getROA(ip4)
-			if roa != nil {
-				ip4roas[ip4] = roa
+			if strings.Contains(ip, ":") {
+				l.name2ip6[ns] = append(l.name2ip6[ns], ip)
+			} else {
+				l.name2ip4[ns] = append(l.name2ip4[ns], ip)
 			}
 		}
 	}
-	ip4list = unique(ip4list)
 
-	for ns := range name2ip6 {
-		for _,ip6 := range name2ip6[ns] {
-			ip6list = append(ip6list, ip6)
-			if _,ok:=ip6roas[ip6]; ok {
-				// already done
+	done := make(map[string]bool)
+	for _, ns := range nameservers {
+		for _, ip := range append(append([]string{}, l.name2ip4[ns]...), l.name2ip6[ns]...) {
+			if done[ip] {
+				continue
+			}
+			done[ip] = true
+			roa, err := getROA(src, ip)
+			if err != nil {
+				l.unknown[ip]++
+			} else if roa != nil {
+				l.roas[ip] = roa
+			}
+			if origins.Load() == nil {
 				continue
 			}
-			roa := getROA(ip6)
-			if roa != nil {
-				ip6roas[ip6] = roa
+			validity, err := addressValidity(src, ip)
+			if err != nil {
+				l.validityErrors[ip] = true
+			}
+			if validity != nil {
+				l.validities[ip] = validity
 			}
 		}
 	}
+	return l
+}
+
+// addressSource tells if an address of ns was resolved, given as glue or both
+func (l *lookups) addressSource(ns, ip string) string {
+	glue := false
+	for _, g := range l.glue[ns] {
+		if g == ip {
+			glue = true
+		}
+	}
+	switch {
+	case glue && l.resolved[ns][ip]:
+		return ADDRESS_BOTH
+	case glue:
+		return ADDRESS_GLUE
+	}
+	return ADDRESS_RESOLVED
+}
+
+// glueMismatch is true if ns has glue that differs from its resolved addresses
+func (l *lookups) glueMismatch(ns string) bool {
+	if len(l.glue[ns]) == 0 {
+		return false
+	}
+	for _, ip := range append(append([]string{}, l.name2ip4[ns]...), l.name2ip6[ns]...) {
+		if l.addressSource(ns, ip) != ADDRESS_BOTH {
+			return true
+		}
+	}
+	return false
+}
+
+// measureNameservers computes the statistic of a domain with the given NS set
+func measureNameservers(domain string, date time.Time, nameservers []string, nsErr error, l *lookups) *DomainResult {
+	stat := &RPKIstat{Domain: domain, Date: date}
+	if nsErr != nil {
+		stat.countError(errorCategory(nsErr))
+	}
+
+	nsErrors := make(map[string]string, 0)
+	ip4list := make([]string, 0)
+	ip6list := make([]string, 0)
+	for _, ns := range nameservers {
+		for _, category := range l.nameErrors[ns] {
+			stat.countError(category)
+		}
+		if len(l.nameErrors[ns]) > 0 {
+			nsErrors[ns] = l.nameErrors[ns][0]
+		}
+		ip4list = append(ip4list, l.name2ip4[ns]...)
+		ip6list = append(ip6list, l.name2ip6[ns]...)
+		if len(l.glue[ns]) > 0 {
+			stat.GlueNames++
+			if l.glueMismatch(ns) {
+				log.Debugf("%s: glue of %s differs from resolved addresses", domain, ns)
+				stat.GlueMismatch++
+			}
+		}
+	}
+	ip4list = unique(ip4list)
 	ip6list = unique(ip6list)
 
 	ta4 := make([]string, 0)
@@ -221,73 +757,115 @@ func domainStat(domain string) (stat *RPKIstat) {
 	asn4 := make([]string, 0)
 	asn6 := make([]string, 0)
 
-	for ip4 := range ip4roas {
-		if ip4roas[ip4] == nil {
-			continue
+	for _, ip4 := range ip4list {
+		if roa := l.roas[ip4]; roa != nil {
+			stat.IPv4roas++
+			ta4 = append(ta4, roa.Ta...)
+			asn4 = append(asn4, roa.Asn...)
+			if roa.MaxLengthInvalid {
+				stat.IPv4maxLengthInvalid++
+			}
+			if roa.MaxLengthPermissive {
+				stat.IPv4maxLengthPermissive++
+			}
 		}
-		for _,ta := range ip4roas[ip4].Ta {
-			ta4 = append(ta4, ta)
+		if l.unknown[ip4] > 0 {
+			stat.IPv4unknown++
+			for i := 0; i < l.unknown[ip4]; i++ {
+				stat.countError(ERROR_ROA)
+			}
 		}
-		for _, asn := range ip4roas[ip4].Asn {
-			asn4 = append(asn4, asn)
+		countValidity(l.validities[ip4], &stat.IPv4valid, &stat.IPv4invalidAS, &stat.IPv4invalidLength, &stat.IPv4notFound)
+		if probe := l.probes[ip4]; probe != nil && probe.Authoritative {
+			stat.IPv4serving++
+			if l.roas[ip4] != nil {
+				stat.IPv4servingROAs++
+			}
 		}
 	}
-	ta4 = unique(ta4)
-	asn4 = unique(asn4)
-
-	for ip6 := range ip6roas {
-		if ip6roas[ip6] == nil {
-			continue
+	for _, ip6 := range ip6list {
+		if roa := l.roas[ip6]; roa != nil {
+			stat.IPv6roas++
+			ta6 = append(ta6, roa.Ta...)
+			asn6 = append(asn6, roa.Asn...)
+			if roa.MaxLengthInvalid {
+				stat.IPv6maxLengthInvalid++
+			}
+			if roa.MaxLengthPermissive {
+				stat.IPv6maxLengthPermissive++
+			}
 		}
-		for _,ta := range ip6roas[ip6].Ta {
-			ta6 = append(ta6, ta)
-		}	
-		for _, asn := range ip6roas[ip6].Asn {
-			asn6 = append(asn6, asn)
+		if l.unknown[ip6] > 0 {
+			stat.IPv6unknown++
+			for i := 0; i < l.unknown[ip6]; i++ {
+				stat.countError(ERROR_ROA)
+			}
+		}
+		countValidity(l.validities[ip6], &stat.IPv6valid, &stat.IPv6invalidAS, &stat.IPv6invalidLength, &stat.IPv6notFound)
+		if probe := l.probes[ip6]; probe != nil && probe.Authoritative {
+			stat.IPv6serving++
+			if l.roas[ip6] != nil {
+				stat.IPv6servingROAs++
+			}
 		}
 	}
-	ta6 = unique(ta6)
-	asn6 = unique(asn6)
 
-	names_full := 0
-	names_partial := 0
-
-	for _,ns := range nameservers {
-    	roas := 0
-    	for _,ip4 := range name2ip4[ns] {
-			if _,ok := ip4roas[ip4]; ok {
-        		roas++ 
-			}
-    	}
-    	for _,ip6 := range name2ip6[ns] {
-			if _,ok := ip6roas[ip6]; ok {
-        		roas++ 
+	if l.probes != nil {
+		stat.Probed = true
+		serials := make(map[uint32]bool)
+		for _, ip := range append(append([]string{}, ip4list...), ip6list...) {
+			if probe := l.probes[ip]; probe != nil && probe.Authoritative {
+				serials[probe.Serial] = true
 			}
-    	}
-	    if roas == len(name2ip4[ns])+len(name2ip6[ns]) {
-        	log.Debugf("%s is full", ns);
-        	names_full++
-    	} else if roas > 0 {
-        	log.Debugf("%s is partial", ns)
-        	names_partial++
 		}
-    }
-
+		stat.Serials = len(serials)
+	}
 
+	for _, ns := range nameservers {
+		coverage := nameCoverage(append(append([]string{}, l.name2ip4[ns]...), l.name2ip6[ns]...), l)
+		log.Debugf("%s is %s", ns, coverage)
+		switch coverage {
+		case COVERAGE_FULL:
+			stat.NamesFull++
+		case COVERAGE_PARTIAL:
+			stat.NamesPartial++
+		}
+	}
 
 	stat.Names = len(nameservers)
-	stat.NamesFull = names_full
-	stat.NamesPartial = names_partial
 	stat.IPv4 = len(ip4list)
-	stat.IPv4roas = len(ip4roas)
 	stat.IPv6 = len(ip6list)
-	stat.IPv6roas = len(ip6roas)
-	stat.TAs4 = len(ta4)
-	stat.TAs6 = len(ta6)
-	stat.AS4 = len(asn4)
-	stat.AS6 = len(asn6)
+	stat.TAs4 = len(unique(ta4))
+	stat.TAs6 = len(unique(ta6))
+	stat.AS4 = len(unique(asn4))
+	stat.AS6 = len(unique(asn6))
+	stat.classify(nsErr, nsErrors)
+	for _, ip := range append(append([]string{}, ip4list...), ip6list...) {
+		if l.validityErrors[ip] {
+			stat.addReason(REASON_VALIDITY_UNAVAILABLE)
+			break
+		}
+	}
 
 	log.Debugf("Result for %s: %v", domain, stat)
 
-	return
+	return buildResult(stat, nameservers, l, nsErrors)
+}
+
+func countValidity(validity *Validity, valid, invalidAS, invalidLength, notFound *int) {
+	if validity == nil {
+		return
+	}
+	switch validity.State {
+	case VALIDITY_VALID:
+		*valid++
+	case VALIDITY_NOT_FOUND:
+		*notFound++
+	case VALIDITY_INVALID:
+		if validity.Reason == INVALID_LENGTH {
+			*invalidLength++
+		} else {
+			*invalidAS++
+		}
+	}
 }
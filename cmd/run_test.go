@@ -0,0 +1,199 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZone answers getNS, getIP4 and getIP6 from records. Names without
+// records are NXDOMAIN, missing types of known names are NODATA.
+func testZone(t *testing.T, records ...string) {
+	t.Helper()
+	zone := make(map[string][]dns.RR)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %s: %s", record, err)
+		}
+		name := strings.ToLower(rr.Header().Name)
+		zone[name] = append(zone[name], rr)
+	}
+	saved := resolveQuery
+	resolveQuery = func(domain string, qtype uint16) (*dns.Msg, error) {
+		name := strings.ToLower(dns.Fqdn(domain))
+		rrs, ok := zone[name]
+		if !ok {
+			return nil, &DNSError{Name: name, Qtype: qtype, Category: ERROR_NXDOMAIN, Err: errors.New("rcode NXDOMAIN")}
+		}
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == qtype {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+		return msg, nil
+	}
+	t.Cleanup(func() { resolveQuery = saved })
+}
+
+// testNameservers are the nameservers of the test zone, see testSource and testOrigins
+var testNameservers = []string{
+	// full: valid and invalid AS
+	"ns1.example.net. A 192.0.2.1",
+	"ns1.example.net. AAAA 2001:db8::1",
+	// full: invalid length
+	"ns2.example.net. A 198.51.100.1",
+	// none: not found
+	"ns3.example.net. A 203.0.113.1",
+	// partial
+	"ns4.example.net. A 192.0.2.4",
+	"ns4.example.net. A 203.0.113.4",
+}
+
+func TestMeasureNameservers(t *testing.T) {
+	testZone(t, append(testNameservers, "missing.example.net. TXT placeholder")...)
+	testOrigins(t)
+	src := testSource()
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		src         ROASource
+		want        RPKIstat
+	}{
+		{"full", []string{"ns1.example.net.", "ns2.example.net."}, src, RPKIstat{
+			Names: 2, NamesFull: 2, IPv4: 2, IPv4roas: 2, IPv6: 1, IPv6roas: 1, TAs4: 2, TAs6: 1, AS4: 2, AS6: 1,
+			IPv4valid: 1, IPv4invalidLength: 1, IPv6invalidAS: 1, IPv4maxLengthInvalid: 1, IPv6maxLengthPermissive: 1,
+			Status: STATUS_OK, Reasons: []string{},
+		}},
+		{"partial", []string{"ns3.example.net.", "ns4.example.net."}, src, RPKIstat{
+			Names: 2, NamesPartial: 1, IPv4: 3, IPv4roas: 1, TAs4: 1, AS4: 1,
+			IPv4valid: 1, IPv4notFound: 2,
+			Status: STATUS_OK, Reasons: []string{},
+		}},
+		{"roa source failing", []string{"ns3.example.net."}, failingSource{}, RPKIstat{
+			Names: 1, IPv4: 1, IPv4unknown: 1, ErrROA: 1,
+			Status: STATUS_PARTIAL, Reasons: []string{REASON_ROA_UNAVAILABLE, REASON_VALIDITY_UNAVAILABLE},
+		}},
+		// the ROAs are known, only the validity is missing
+		{"validation failing", []string{"ns1.example.net."}, validateFailingSource{src}, RPKIstat{
+			Names: 1, NamesFull: 1, IPv4: 1, IPv4roas: 1, IPv6: 1, IPv6roas: 1, TAs4: 1, TAs6: 1, AS4: 1, AS6: 1,
+			IPv6maxLengthPermissive: 1,
+			Status:                  STATUS_PARTIAL, Reasons: []string{REASON_VALIDITY_UNAVAILABLE},
+		}},
+		{"no addresses", []string{"missing.example.net."}, src, RPKIstat{
+			Names:  1,
+			Status: STATUS_OK, Reasons: []string{},
+		}},
+		{"address lookup failing", []string{"ns1.example.net.", "unknown.example.net."}, src, RPKIstat{
+			Names: 2, NamesFull: 1, IPv4: 1, IPv4roas: 1, IPv6: 1, IPv6roas: 1, TAs4: 1, TAs6: 1, AS4: 1, AS6: 1,
+			IPv4valid: 1, IPv6invalidAS: 1, IPv6maxLengthPermissive: 1, ErrNxdomain: 2,
+			Status: STATUS_PARTIAL, Reasons: []string{REASON_ADDRESS + ERROR_NXDOMAIN},
+		}},
+		{"no nameservers", []string{}, src, RPKIstat{
+			Status: STATUS_FAILED, Reasons: []string{REASON_NO_NAMESERVERS},
+		}},
+	}
+	date := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := lookupNameservers(tt.nameservers, nil, tt.src)
+			result := measureNameservers("example.", date, tt.nameservers, nil, l)
+			tt.want.Domain = "example."
+			tt.want.Date = date
+			if !reflect.DeepEqual(*result.Stat, tt.want) {
+				t.Errorf("measureNameservers =\n%+v\nwant\n%+v", *result.Stat, tt.want)
+			}
+			if len(result.Nameservers) != len(tt.nameservers) {
+				t.Errorf("%d nameserver results, want %d", len(result.Nameservers), len(tt.nameservers))
+			}
+		})
+	}
+}
+
+func TestNameCoverage(t *testing.T) {
+	l := &lookups{
+		roas:    map[string]*ROA{"192.0.2.1": {}, "192.0.2.2": {}},
+		unknown: map[string]int{"198.51.100.1": 1},
+	}
+	tests := []struct {
+		name string
+		ips  []string
+		want string
+	}{
+		{"full", []string{"192.0.2.1", "192.0.2.2"}, COVERAGE_FULL},
+		{"partial", []string{"192.0.2.1", "203.0.113.1"}, COVERAGE_PARTIAL},
+		{"partial despite unknown", []string{"192.0.2.1", "198.51.100.1", "203.0.113.1"}, COVERAGE_PARTIAL},
+		{"none", []string{"203.0.113.1"}, COVERAGE_NONE},
+		{"no addresses", []string{}, COVERAGE_NONE},
+		// a failed lookup is neither covered nor uncovered
+		{"covered and unknown", []string{"192.0.2.1", "198.51.100.1"}, COVERAGE_UNKNOWN},
+		{"uncovered and unknown", []string{"198.51.100.1", "203.0.113.1"}, COVERAGE_UNKNOWN},
+		{"unknown", []string{"198.51.100.1"}, COVERAGE_UNKNOWN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nameCoverage(tt.ips, l); got != tt.want {
+				t.Errorf("nameCoverage = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainStat(t *testing.T) {
+	testZone(t, append(testNameservers,
+		"example. NS ns1.example.net.",
+		"example. NS ns4.example.net.",
+		"empty. SOA ns1.example.net. hostmaster.example.net. 1 3600 600 86400 60",
+	)...)
+	testOrigins(t)
+
+	tests := []struct {
+		domain  string
+		names   int
+		status  string
+		reasons []string
+		wantErr bool
+	}{
+		{"example.", 2, STATUS_OK, []string{}, false},
+		{"empty.", 0, STATUS_FAILED, []string{REASON_NO_NAMESERVERS}, false},
+		{"nxdomain.", 0, STATUS_FAILED, []string{REASON_NS + ERROR_NXDOMAIN}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			result, err := domainStat(tt.domain, testSource())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("domainStat error %v, want error %t", err, tt.wantErr)
+			}
+			stat := result.Stat
+			if stat.Names != tt.names || stat.Status != tt.status || !reflect.DeepEqual(stat.Reasons, tt.reasons) {
+				t.Errorf("domainStat = names %d status %s reasons %v, want %d %s %v", stat.Names, stat.Status, stat.Reasons, tt.names, tt.status, tt.reasons)
+			}
+			if stat.NSSet != NS_SET_CHILD {
+				t.Errorf("NS set %s, want %s", stat.NSSet, NS_SET_CHILD)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// version is set at build time with
+// -ldflags "-X github.com/ulrichwisser/rpkistats/cmd.version=v1.2.3"
+var version string
+
+// status of a run in RUNS
+const (
+	RUN_RUNNING = "running"
+	RUN_SUCCESS = "success"
+	RUN_FAILED  = "failed"
+)
+
+// Run describes one run over a domain list
+type Run struct {
+	ID        int64
+	Started   time.Time
+	Finished  time.Time
+	Resolver  string
+	ROASource SourceMetadata
+	InputFile string
+	InputHash string
+	Version   string
+	Domains   int
+	DNSErrors int64
+	ROAErrors int64
+	Status    string
+	// domains of the input file saved to the database
+	Done int
+}
+
+// toolVersion returns the version of rpkistats
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// fileHash returns the hex encoded sha256 of a file
+func fileHash(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newRun starts a run
+func newRun(resolver string, src ROASource, inputfile string) (*Run, error) {
+	hash, err := fileHash(inputfile)
+	if err != nil {
+		return nil, err
+	}
+	return &Run{
+		Started:   time.Now().UTC(),
+		Resolver:  resolver,
+		ROASource: src.Metadata(),
+		InputFile: inputfile,
+		InputHash: hash,
+		Version:   toolVersion(),
+	}, nil
+}
+
+// finish records end time and the final ROA source metadata.
+// Domains are added to those of a resumed run.
+func (r *Run) finish(src ROASource, domains int) {
+	r.Finished = time.Now().UTC()
+	r.ROASource = src.Metadata()
+	r.Domains += domains
+}
+
+// addErrors adds the failed lookups of results to the error counters of the run
+func (r *Run) addErrors(results []*DomainResult) {
+	dns, roa := countErrors(results)
+	r.DNSErrors += dns
+	r.ROAErrors += roa
+}
+
+// countErrors sums the failed DNS and ROA source lookups of results
+func countErrors(results []*DomainResult) (dns int64, roa int64) {
+	for _, result := range results {
+		stat := result.Stat
+		dns += int64(stat.ErrServfail + stat.ErrTimeout + stat.ErrNxdomain + stat.ErrDNS)
+		roa += int64(stat.ErrROA)
+	}
+	return
+}
+
+// startRun2db inserts the run and sets its ID
+func startRun2db(db *Storage, run *Run) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start DB transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	run.ID, err = tx.Insert("INSERT INTO RUNS(STARTED, RESOLVER, ROA_SOURCE, ROA_SERIAL, ROA_UPDATED, INPUT_FILE, INPUT_HASH, VERSION, STATUS) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		run.Started, run.Resolver, run.ROASource.Name, nullSerial(run.ROASource), nullTime(run.ROASource.LastUpdate), run.InputFile, run.InputHash, run.Version, RUN_RUNNING)
+	if err != nil {
+		return fmt.Errorf("could not insert into RUNS: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit to DB: %w", err)
+	}
+	log.Debugf("INSERT INTO RUNS %d, %s, %s, %s, %s", run.ID, run.Started, run.Resolver, run.ROASource.Name, run.InputFile)
+	return nil
+}
+
+// finishRun2db updates the run after all results are saved
+func finishRun2db(db *Storage, run *Run) error {
+	_, err := db.Exec("UPDATE RUNS SET FINISHED = ?, ROA_SERIAL = ?, ROA_UPDATED = ?, DOMAINS = ?, DNS_ERRORS = ?, ROA_ERRORS = ?, STATUS = ? WHERE ID = ?",
+		run.Finished, nullSerial(run.ROASource), nullTime(run.ROASource.LastUpdate), run.Domains, run.DNSErrors, run.ROAErrors, RUN_SUCCESS, run.ID)
+	if err != nil {
+		return fmt.Errorf("could not update RUNS: %w", err)
+	}
+	run.Status = RUN_SUCCESS
+	log.Debugf("UPDATE RUNS %d, %s, Domains %d, DNS errors %d, ROA errors %d", run.ID, run.Finished, run.Domains, run.DNSErrors, run.ROAErrors)
+	return nil
+}
+
+// failRun2db records why a run failed, saved results and the checkpoint stay
+func failRun2db(db *Storage, run *Run, cause error) error {
+	_, err := db.Exec("UPDATE RUNS SET FINISHED = ?, STATUS = ?, ERROR = ? WHERE ID = ?", time.Now().UTC(), RUN_FAILED, cause.Error(), run.ID)
+	if err != nil {
+		return fmt.Errorf("could not update RUNS: %w", err)
+	}
+	run.Status = RUN_FAILED
+	log.Debugf("UPDATE RUNS %d failed: %s", run.ID, cause)
+	return nil
+}
+
+// loadRun2db reads a run to be resumed
+func loadRun2db(db *Storage, id int64) (*Run, error) {
+	run := &Run{ID: id}
+	err := db.QueryRow("SELECT STARTED, RESOLVER, ROA_SOURCE, INPUT_FILE, INPUT_HASH, VERSION, DONE, DNS_ERRORS, ROA_ERRORS, STATUS FROM RUNS WHERE ID = ?", id).
+		Scan(&run.Started, &run.Resolver, &run.ROASource.Name, &run.InputFile, &run.InputHash, &run.Version, &run.Done, &run.DNSErrors, &run.ROAErrors, &run.Status)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("run %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read run %d: %w", id, err)
+	}
+	// only saved domains count for a resumed run
+	run.Domains = run.Done
+	return run, nil
+}
+
+// resumeRun2db sets a run to running again
+func resumeRun2db(db *Storage, run *Run) error {
+	_, err := db.Exec("UPDATE RUNS SET STATUS = ?, ERROR = NULL, FINISHED = NULL WHERE ID = ?", RUN_RUNNING, run.ID)
+	if err != nil {
+		return fmt.Errorf("could not update RUNS: %w", err)
+	}
+	run.Status = RUN_RUNNING
+	log.Debugf("UPDATE RUNS %d resumed after %d domains", run.ID, run.Done)
+	return nil
+}
+
+// interruptedRuns2db marks runs that are still running as failed.
+// Only call it while holding the run lock, then no other run can be active.
+func interruptedRuns2db(db *Storage) error {
+	result, err := db.Exec("UPDATE RUNS SET STATUS = ?, ERROR = ? WHERE STATUS = ?", RUN_FAILED, "interrupted", RUN_RUNNING)
+	if err != nil {
+		return fmt.Errorf("could not update RUNS: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		log.Warnf("Marked %d interrupted runs as failed", n)
+	}
+	return nil
+}
+
+func nullSerial(metadata SourceMetadata) sql.NullInt64 {
+	// only RTR has a serial
+	return sql.NullInt64{Int64: int64(metadata.Serial), Valid: metadata.Serial != 0}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(n int, valid bool) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: valid}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the error counters of a run are those of its own results
+func TestRunErrors(t *testing.T) {
+	testZone(t, append(testNameservers,
+		"example. NS ns1.example.net.",
+		"example. NS unknown.example.net.",
+	)...)
+	testOrigins(t)
+	domainfile := filepath.Join(t.TempDir(), "domains.list")
+	if err := os.WriteFile(domainfile, []byte("example\nnxdomain\n"), 0o644); err != nil {
+		t.Fatalf("write domain file: %s", err)
+	}
+
+	for _, withDB := range []bool{false, true} {
+		var db *Storage
+		if withDB {
+			db = testDB(t)
+		}
+		run, err := newRun("127.0.0.1", testSource(), domainfile)
+		if err != nil {
+			t.Fatalf("newRun: %s", err)
+		}
+		if db != nil {
+			if err := startRun2db(db, run); err != nil {
+				t.Fatalf("startRun2db: %s", err)
+			}
+		}
+		// a measurement outside of the run, like an API check
+		if _, err := domainStat("nxdomain", testSource()); err == nil {
+			t.Fatalf("domainStat of nxdomain returned no error")
+		}
+		if _, err := measureRun(db, run, testSource()); err != nil {
+			t.Fatalf("measureRun: %s", err)
+		}
+		// NXDOMAIN of the NS set of nxdomain, A and AAAA of unknown.example.net
+		if run.DNSErrors != 3 || run.ROAErrors != 0 {
+			t.Errorf("db %t: run errors %d/%d, want 3/0", withDB, run.DNSErrors, run.ROAErrors)
+		}
+		if db == nil {
+			continue
+		}
+		var dnsErrors, roaErrors int64
+		if err := db.QueryRow("SELECT DNS_ERRORS, ROA_ERRORS FROM RUNS WHERE ID = ?", run.ID).Scan(&dnsErrors, &roaErrors); err != nil {
+			t.Fatalf("read RUNS: %s", err)
+		}
+		if dnsErrors != 3 || roaErrors != 0 {
+			t.Errorf("RUNS errors %d/%d, want 3/0", dnsErrors, roaErrors)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const METRICS_NAMESPACE = "rpkistats"
+
+// Metrics holds the Prometheus collectors of serve mode
+type Metrics struct {
+	registry *prometheus.Registry
+
+	names        *prometheus.GaugeVec
+	namesFull    *prometheus.GaugeVec
+	namesPartial *prometheus.GaugeVec
+	addresses    *prometheus.GaugeVec
+	addressesROA *prometheus.GaugeVec
+	trustAnchors *prometheus.GaugeVec
+	originASNs   *prometheus.GaugeVec
+	lookupErrors *prometheus.GaugeVec
+	status       *prometheus.GaugeVec
+
+	domains     prometheus.Gauge
+	runDuration prometheus.Gauge
+	lastRun     prometheus.Gauge
+	runs        prometheus.Counter
+	skipped     prometheus.Counter
+	failed      prometheus.Counter
+	dnsErrors   prometheus.Counter
+	roaErrors   prometheus.Counter
+
+	// domains of the last run, used to remove domains dropped from the list
+	seen map[string]bool
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Periodically get RPKI statistics, export metrics and answer domain checks",
+	Long: `Periodically get RPKI statistics for a list of domain names, optionally save them to the database
+and export them as Prometheus metrics on /metrics.
+With --http single domains can be checked with GET /v1/domain/{name}.`,
+	PreRun: bindFlags,
+	Run:    execServe,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringP(DOMAIN_FILE, DOMAIN_FILE_SHORT, "", "file with a list of domain names")
+	serveCmd.Flags().StringP(ROUTINATOR, ROUTINATOR_SHORT, "", "address (including port) of the routinator instance to use")
+	serveCmd.Flags().StringSliceP(RESOLVER, RESOLVER_SHORT, nil, "resolvers to use, [udp://|tcp://]ip[:port] (repeat or comma separated, default transport tcp)")
+	serveCmd.Flags().String(RESOLVER_MODE, RESOLVER_ROUNDROBIN, "use of several resolvers: roundrobin, failover or compare (compare the answers of all resolvers)")
+	serveCmd.Flags().String(VRPS, "", "VRP export (Routinator json, jsonext or csv, rpki-client json) used instead of routinator")
+	serveCmd.Flags().String(RTR, "", "address (host:port) of an RTR cache used instead of routinator")
+	serveCmd.Flags().StringP(PFX2AS, PFX2AS_SHORT, "", "prefix to origin table (pfx2as or bgpdump -m output) used for prefix lookup and route origin validation")
+	serveCmd.Flags().IntP(WORKERS, WORKERS_SHORT, 10, "number of domains processed concurrently")
+	serveCmd.Flags().Int(DNS_LIMIT, 50, "maximum number of DNS queries in flight")
+	serveCmd.Flags().Int(ROUTINATOR_LIMIT, 10, "maximum number of Routinator requests in flight")
+	serveCmd.Flags().String(LISTEN, ":9323", "address to listen on for metrics requests")
+	serveCmd.Flags().String(SCHEDULE, "1h", "interval (e.g. 30m) or cron expression (e.g. \"0 3 * * *\") for domain list runs")
+	serveCmd.Flags().String(LOCK_FILE, defaultLockFile(), "lock file preventing overlapping domain list runs")
+	serveCmd.Flags().Bool(DETAIL, false, "save results per nameserver and address")
+	serveCmd.Flags().Int(BATCH, 100, "number of domains saved to the database per transaction")
+	serveCmd.Flags().Bool(HTTP, false, "answer domain checks on /v1/domain/{name}")
+	serveCmd.Flags().Duration(CACHE_TTL, 10*time.Minute, "time domain check results are cached")
+	serveCmd.Flags().Float64(HTTP_RATE, 1, "domain checks per second and client (0 for no limit)")
+	serveCmd.Flags().Int(HTTP_BURST, 5, "domain checks a client may burst above the rate")
+	serveCmd.Flags().Int(HTTP_LIMIT, 4, "maximum number of domain checks in progress")
+	serveCmd.Flags().Bool(DELEGATION, false, "ask the parent zone for the delegation and compare it with the NS set of the child")
+	serveCmd.Flags().String(NS_SET, NS_SET_CHILD, "NS set the statistics are based on: child or parent (implies --delegation)")
+	serveCmd.Flags().Bool(PROBE, false, "send an SOA query to every nameserver address and record the answers")
+	serveCmd.Flags().StringSlice(ROOTS, nil, "addresses of the root servers to start the delegation lookup from (default IANA root servers)")
+}
+
+func execServe(cmd *cobra.Command, args []string) {
+
+	roaSource := prepareRun(cmd)
+
+	if viper.GetString(DOMAIN_FILE) == "" && !viper.GetBool(HTTP) {
+		cmd.Help()
+		log.Fatal("Domain list or --http must be given.")
+	}
+	domainfile := viper.GetString(DOMAIN_FILE)
+	log.Debugf("Domain file: %s", domainfile)
+
+	schedule, interval, err := parseSchedule(viper.GetString(SCHEDULE))
+	if err != nil {
+		log.Fatalf("Invalid schedule %s: %s", viper.GetString(SCHEDULE), err)
+	}
+
+	// runs are saved if a database is configured
+	var db *Storage
+	if viper.GetString(DBCREDENTIALS) != "" && domainfile != "" {
+		db, err = openDB()
+		if err != nil {
+			log.Fatalf("Could not open database: %s", err)
+		}
+		defer db.Close()
+	}
+
+	// the API uses the ROA source of the current run
+	var sourceMutex sync.RWMutex
+	currentSource := func() ROASource {
+		sourceMutex.RLock()
+		defer sourceMutex.RUnlock()
+		return roaSource
+	}
+
+	metrics := newMetrics()
+	cache := newResultCache(viper.GetDuration(CACHE_TTL))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	if viper.GetBool(HTTP) {
+		api := newAPI(currentSource, cache, viper.GetFloat64(HTTP_RATE), viper.GetInt(HTTP_BURST), viper.GetInt(HTTP_LIMIT))
+		api.register(mux)
+	}
+	go func() {
+		log.Infof("Listening on %s", viper.GetString(LISTEN))
+		log.Fatal(http.ListenAndServe(viper.GetString(LISTEN), mux).Error())
+	}()
+
+	if domainfile == "" {
+		// only answer domain checks
+		select {}
+	}
+
+	// intervals start with a run, cron expressions wait for their first time
+	next := time.Now()
+	if !interval {
+		next = schedule.Next(next)
+	}
+	for first := true; ; first = false {
+		log.Infof("Next run at %s", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
+
+		// VRPs and origins change between runs, keep the old ones if that fails
+		if !first || !interval {
+			if src, err := selectROASource(); err != nil {
+				log.Errorf("Could not refresh ROA source: %s", err)
+			} else {
+				sourceMutex.Lock()
+				roaSource = src
+				sourceMutex.Unlock()
+			}
+			if viper.GetString(PFX2AS) != "" {
+				if table, err := loadOriginTable(viper.GetString(PFX2AS)); err != nil {
+					log.Errorf("Could not refresh prefix to origin table: %s", err)
+				} else {
+					origins.Store(table)
+				}
+			}
+		}
+
+		started := time.Now()
+		scheduledRun(domainfile, currentSource(), db, metrics, cache)
+
+		next = schedule.Next(started)
+		if next.Before(time.Now()) {
+			log.Warnf("Run took longer than the schedule, skipping to the next time")
+			next = schedule.Next(time.Now())
+		}
+	}
+}
+
+// parseSchedule accepts a duration or a cron expression and reports
+// whether the schedule is an interval
+func parseSchedule(spec string) (cron.Schedule, bool, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Second {
+			return nil, false, fmt.Errorf("interval must be at least one second")
+		}
+		return cron.Every(d), true, nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	return schedule, false, err
+}
+
+// scheduledRun runs the domain list unless another run holds the lock
+func scheduledRun(domainfile string, src ROASource, db *Storage, metrics *Metrics, cache *ResultCache) {
+	lock, err := lockRun(viper.GetString(LOCK_FILE))
+	if err != nil {
+		log.Warnf("Skipping run, could not lock %s: %s", viper.GetString(LOCK_FILE), err)
+		metrics.skipped.Inc()
+		return
+	}
+	defer lock.unlock()
+
+	run, err := newRun(resolverList(), src, domainfile)
+	if err == nil && db != nil {
+		err = interruptedRuns2db(db)
+		if err == nil {
+			err = startRun2db(db, run)
+		}
+	}
+	if err != nil {
+		log.Errorf("Could not start run: %s", err)
+		metrics.failed.Inc()
+		return
+	}
+
+	results, err := measureRun(db, run, src)
+	if err != nil {
+		log.Errorf("Run failed: %s", err)
+		metrics.failed.Inc()
+		return
+	}
+	log.Infof("Run finished after %s, %d domains, DNS errors %d, ROA errors %d", run.Finished.Sub(run.Started), run.Domains, run.DNSErrors, run.ROAErrors)
+
+	metrics.update(run, results)
+	for _, result := range results {
+		if !result.Stat.failed() {
+			cache.put(result)
+		}
+	}
+}
+
+func newMetrics() *Metrics {
+	domainGauge := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: METRICS_NAMESPACE, Name: name, Help: help}, append([]string{"domain"}, labels...))
+	}
+	m := &Metrics{
+		registry:     prometheus.NewRegistry(),
+		names:        domainGauge("names", "Number of nameserver names"),
+		namesFull:    domainGauge("names_roa_full", "Number of nameserver names with ROAs for all addresses"),
+		namesPartial: domainGauge("names_roa_partial", "Number of nameserver names with ROAs for some addresses"),
+		addresses:    domainGauge("addresses", "Number of nameserver addresses", "family"),
+		addressesROA: domainGauge("addresses_roa", "Number of nameserver addresses covered by a ROA", "family"),
+		trustAnchors: domainGauge("trust_anchors", "Number of trust anchors of the ROAs", "family"),
+		originASNs:   domainGauge("origin_asns", "Number of origin AS of the ROAs", "family"),
+		lookupErrors: domainGauge("lookup_errors", "Number of failed lookups", "category"),
+		status:       domainGauge("status", "Status of the last measurement, 1 for the current status", "status"),
+		domains: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: METRICS_NAMESPACE, Name: "domains",
+			Help: "Number of domains in the last run"}),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: METRICS_NAMESPACE, Name: "run_duration_seconds",
+			Help: "Duration of the last run"}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: METRICS_NAMESPACE, Name: "last_run_timestamp_seconds",
+			Help: "End time of the last run"}),
+		runs: prometheus.NewCounter(prometheus.CounterOpts{Namespace: METRICS_NAMESPACE, Name: "runs_total",
+			Help: "Number of finished runs"}),
+		skipped: prometheus.NewCounter(prometheus.CounterOpts{Namespace: METRICS_NAMESPACE, Name: "runs_skipped_total",
+			Help: "Number of runs skipped because another run held the lock"}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{Namespace: METRICS_NAMESPACE, Name: "runs_failed_total",
+			Help: "Number of runs that failed"}),
+		dnsErrors: prometheus.NewCounter(prometheus.CounterOpts{Namespace: METRICS_NAMESPACE, Name: "dns_errors_total",
+			Help: "Number of failed DNS lookups"}),
+		roaErrors: prometheus.NewCounter(prometheus.CounterOpts{Namespace: METRICS_NAMESPACE, Name: "roa_errors_total",
+			Help: "Number of failed ROA source (Routinator, RTR, VRP file) lookups"}),
+		seen: make(map[string]bool),
+	}
+	m.registry.MustRegister(
+		m.names, m.namesFull, m.namesPartial, m.addresses, m.addressesROA, m.trustAnchors, m.originASNs, m.lookupErrors, m.status,
+		m.domains, m.runDuration, m.lastRun, m.runs, m.skipped, m.failed, m.dnsErrors, m.roaErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// update sets the metrics from the results of a finished run
+func (m *Metrics) update(run *Run, results []*DomainResult) {
+	seen := make(map[string]bool, len(results))
+	for _, result := range results {
+		stat := result.Stat
+		seen[stat.Domain] = true
+		for _, status := range []string{STATUS_OK, STATUS_PARTIAL, STATUS_FAILED} {
+			current := 0.0
+			if stat.Status == status {
+				current = 1
+			}
+			m.status.WithLabelValues(stat.Domain, status).Set(current)
+		}
+		m.lookupErrors.WithLabelValues(stat.Domain, ERROR_SERVFAIL).Set(float64(stat.ErrServfail))
+		m.lookupErrors.WithLabelValues(stat.Domain, ERROR_TIMEOUT).Set(float64(stat.ErrTimeout))
+		m.lookupErrors.WithLabelValues(stat.Domain, ERROR_NXDOMAIN).Set(float64(stat.ErrNxdomain))
+		m.lookupErrors.WithLabelValues(stat.Domain, ERROR_DNS).Set(float64(stat.ErrDNS))
+		m.lookupErrors.WithLabelValues(stat.Domain, ERROR_ROA).Set(float64(stat.ErrROA))
+
+		// a failed measurement has no data, drop the series instead of exporting zeros
+		if stat.failed() {
+			m.deleteData(stat.Domain)
+			continue
+		}
+		m.names.WithLabelValues(stat.Domain).Set(float64(stat.Names))
+		m.namesFull.WithLabelValues(stat.Domain).Set(float64(stat.NamesFull))
+		m.namesPartial.WithLabelValues(stat.Domain).Set(float64(stat.NamesPartial))
+		m.addresses.WithLabelValues(stat.Domain, "ipv4").Set(float64(stat.IPv4))
+		m.addresses.WithLabelValues(stat.Domain, "ipv6").Set(float64(stat.IPv6))
+		m.addressesROA.WithLabelValues(stat.Domain, "ipv4").Set(float64(stat.IPv4roas))
+		m.addressesROA.WithLabelValues(stat.Domain, "ipv6").Set(float64(stat.IPv6roas))
+		m.trustAnchors.WithLabelValues(stat.Domain, "ipv4").Set(float64(stat.TAs4))
+		m.trustAnchors.WithLabelValues(stat.Domain, "ipv6").Set(float64(stat.TAs6))
+		m.originASNs.WithLabelValues(stat.Domain, "ipv4").Set(float64(stat.AS4))
+		m.originASNs.WithLabelValues(stat.Domain, "ipv6").Set(float64(stat.AS6))
+	}
+
+	// remove domains no longer in the domain list
+	for domain := range m.seen {
+		if seen[domain] {
+			continue
+		}
+		m.deleteData(domain)
+		m.lookupErrors.DeletePartialMatch(prometheus.Labels{"domain": domain})
+		m.status.DeletePartialMatch(prometheus.Labels{"domain": domain})
+	}
+	m.seen = seen
+
+	m.domains.Set(float64(run.Domains))
+	m.runDuration.Set(run.Finished.Sub(run.Started).Seconds())
+	m.lastRun.Set(float64(run.Finished.Unix()))
+	m.runs.Inc()
+	m.dnsErrors.Add(float64(run.DNSErrors))
+	m.roaErrors.Add(float64(run.ROAErrors))
+}
+
+// deleteData removes the measured values of domain
+func (m *Metrics) deleteData(domain string) {
+	labels := prometheus.Labels{"domain": domain}
+	for _, vec := range []*prometheus.GaugeVec{m.names, m.namesFull, m.namesPartial, m.addresses, m.addressesROA, m.trustAnchors, m.originASNs} {
+		vec.DeletePartialMatch(labels)
+	}
+}
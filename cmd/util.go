@@ -34,4 +34,42 @@ func unique(strlist []string) (resultlist []string) {
 	}
 
 	return
-}
\ No newline at end of file
+}
+
+// limiter bounds the number of concurrent operations.
+// A nil limiter does not limit anything.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n < 1 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+func (l limiter) acquire() {
+	if l == nil {
+		return
+	}
+	l <- struct{}{}
+}
+
+func (l limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
+
+// tryAcquire takes a slot if one is free and reports whether it did
+func (l limiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// VRP is a validated ROA payload
+type VRP struct {
+	Prefix    netip.Prefix `json:"prefix"`
+	MaxLength int          `json:"max_length"`
+	ASN       uint32       `json:"asn"`
+	TA        string       `json:"ta,omitempty"`
+}
+
+// VRPTable holds a full set of VRPs in a binary prefix trie per address
+// family and, if the source provides them, the ASPA records
+type VRPTable struct {
+	name      string
+	v4        *prefixNode
+	v6        *prefixNode
+	aspas     map[uint32][]uint32
+	count     int
+	serial    uint32
+	generated time.Time
+}
+
+func newVRPTable() *VRPTable {
+	return &VRPTable{v4: &prefixNode{}, v6: &prefixNode{}, aspas: make(map[uint32][]uint32)}
+}
+
+// prefixNode is a node of the prefix trie, the path from the root
+// spells the prefix bits of the VRPs stored in the node
+type prefixNode struct {
+	children [2]*prefixNode
+	vrps     []VRP
+}
+
+// bit returns bit i of addr, counted from the most significant bit
+func bit(addr netip.Addr, i int) int {
+	b := addr.AsSlice()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// root returns the trie for the address family of addr
+func (t *VRPTable) root(addr netip.Addr) *prefixNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+// newStaticSource returns an in-memory ROA source holding exactly vrps
+func newStaticSource(name string, vrps ...VRP) *VRPTable {
+	table := newVRPTable()
+	table.name = name
+	for _, vrp := range vrps {
+		table.add(vrp)
+	}
+	return table
+}
+
+// loadVRPFile reads a VRP export. Supported are the json, jsonext and csv
+// output formats of Routinator and the json output of rpki-client.
+func loadVRPFile(filename string) (*VRPTable, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading VRP file %s: %w", filename, err)
+	}
+
+	var table *VRPTable
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		table, err = parseVRPJSON(data)
+	} else {
+		table, err = parseVRPCSV(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing VRP file %s: %w", filename, err)
+	}
+
+	table.name = "file " + filename
+	log.Debugf("Loaded %d VRPs from %s", table.count, filename)
+	return table, nil
+}
+
+func (t *VRPTable) add(vrp VRP) {
+	vrp.Prefix = vrp.Prefix.Masked()
+	if vrp.MaxLength < vrp.Prefix.Bits() {
+		vrp.MaxLength = vrp.Prefix.Bits()
+	}
+	addr := vrp.Prefix.Addr()
+	node := t.root(addr)
+	for i := 0; i < vrp.Prefix.Bits(); i++ {
+		b := bit(addr, i)
+		if node.children[b] == nil {
+			node.children[b] = &prefixNode{}
+		}
+		node = node.children[b]
+	}
+	node.vrps = append(node.vrps, vrp)
+	t.count++
+}
+
+func (t *VRPTable) remove(vrp VRP) {
+	vrp.Prefix = vrp.Prefix.Masked()
+	addr := vrp.Prefix.Addr()
+	// path from the root to the node of the prefix
+	path := []*prefixNode{t.root(addr)}
+	for i := 0; i < vrp.Prefix.Bits(); i++ {
+		next := path[i].children[bit(addr, i)]
+		if next == nil {
+			return
+		}
+		path = append(path, next)
+	}
+	node := path[len(path)-1]
+	for i, v := range node.vrps {
+		if v.ASN == vrp.ASN && v.MaxLength == vrp.MaxLength {
+			node.vrps = append(node.vrps[:i], node.vrps[i+1:]...)
+			t.count--
+			break
+		}
+	}
+	// prune nodes left without VRPs and children
+	for i := len(path) - 1; i > 0; i-- {
+		n := path[i]
+		if len(n.vrps) > 0 || n.children[0] != nil || n.children[1] != nil {
+			break
+		}
+		path[i-1].children[bit(addr, i-1)] = nil
+	}
+}
+
+// covering returns all VRPs whose prefix covers prefix, most specific first
+func (t *VRPTable) covering(prefix netip.Prefix) []VRP {
+	addr := prefix.Addr().Unmap()
+	bits := prefix.Bits()
+	if bits > addr.BitLen() {
+		bits = addr.BitLen()
+	}
+	var levels [][]VRP
+	node := t.root(addr)
+	for i := 0; node != nil; i++ {
+		if len(node.vrps) > 0 {
+			levels = append(levels, node.vrps)
+		}
+		if i >= bits {
+			break
+		}
+		node = node.children[bit(addr, i)]
+	}
+	result := make([]VRP, 0)
+	for i := len(levels) - 1; i >= 0; i-- {
+		result = append(result, levels[i]...)
+	}
+	return result
+}
+
+// Lookup returns all VRPs covering prefix
+func (t *VRPTable) Lookup(prefix netip.Prefix) ([]VRP, error) {
+	return t.covering(prefix), nil
+}
+
+// Validate does route origin validation against the VRPs in the table
+func (t *VRPTable) Validate(prefix netip.Prefix, asn uint32) (*Validity, error) {
+	return validate(t.covering(prefix), prefix, asn), nil
+}
+
+func (t *VRPTable) Metadata() SourceMetadata {
+	return SourceMetadata{Name: t.name, Serial: t.serial, LastUpdate: t.generated}
+}
+
+// parseVRPJSON handles the json formats of Routinator and rpki-client.
+// Routinator writes the ASN as "AS13335", rpki-client as a number,
+// Routinator jsonext has the trust anchor in the list of sources.
+func parseVRPJSON(data []byte) (*VRPTable, error) {
+	var export struct {
+		Metadata struct {
+			Generated     int64  `json:"generated"`
+			GeneratedTime string `json:"generatedTime"`
+			BuildTime     string `json:"buildtime"`
+		} `json:"metadata"`
+		Roas []struct {
+			ASN       json.RawMessage `json:"asn"`
+			Prefix    string          `json:"prefix"`
+			MaxLength int             `json:"maxLength"`
+			TA        string          `json:"ta"`
+			Source    []struct {
+				TAL string `json:"tal"`
+			} `json:"source"`
+		} `json:"roas"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	table := newVRPTable()
+	switch {
+	case export.Metadata.Generated > 0:
+		table.generated = time.Unix(export.Metadata.Generated, 0).UTC()
+	case export.Metadata.BuildTime != "":
+		table.generated, _ = time.Parse(time.RFC3339, export.Metadata.BuildTime)
+	}
+
+	for _, r := range export.Roas {
+		prefix, err := netip.ParsePrefix(r.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		asn, err := parseASN(strings.Trim(string(r.ASN), `"`))
+		if err != nil {
+			return nil, err
+		}
+		ta := r.TA
+		if ta == "" && len(r.Source) > 0 {
+			ta = r.Source[0].TAL
+		}
+		table.add(VRP{Prefix: prefix, MaxLength: r.MaxLength, ASN: asn, TA: ta})
+	}
+	return table, nil
+}
+
+// parseVRPCSV handles the csv format of Routinator
+//
+//	ASN,IP Prefix,Max Length,Trust Anchor
+//	AS13335,1.0.0.0/24,24,apnic
+func parseVRPCSV(r io.Reader) (*VRPTable, error) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"asn", "ip prefix", "max length"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %q missing", name)
+		}
+	}
+	tacol, hasta := columns["trust anchor"]
+
+	table := newVRPTable()
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := netip.ParsePrefix(record[columns["ip prefix"]])
+		if err != nil {
+			return nil, err
+		}
+		asn, err := parseASN(record[columns["asn"]])
+		if err != nil {
+			return nil, err
+		}
+		maxlen, err := strconv.Atoi(record[columns["max length"]])
+		if err != nil {
+			return nil, err
+		}
+		vrp := VRP{Prefix: prefix, MaxLength: maxlen, ASN: asn}
+		if hasta && tacol < len(record) {
+			vrp.TA = record[tacol]
+		}
+		table.add(vrp)
+	}
+	return table, nil
+}
+
+// parseASN accepts "AS13335" as well as "13335"
+func parseASN(as string) (uint32, error) {
+	as = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(as)), "AS")
+	asn, err := strconv.ParseUint(as, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse ASN %s: %s", as, err)
+	}
+	return uint32(asn), nil
+}
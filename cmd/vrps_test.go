@@ -0,0 +1,94 @@
+/*
+Copyright © 2025 Ulrich Wisser
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func vrp(prefix string, maxLength int, asn uint32) VRP {
+	return VRP{Prefix: netip.MustParsePrefix(prefix), MaxLength: maxLength, ASN: asn}
+}
+
+func TestVRPTableCovering(t *testing.T) {
+	table := newStaticSource("test",
+		vrp("0.0.0.0/0", 0, 1),
+		vrp("192.0.0.0/16", 24, 64496),
+		vrp("192.0.2.0/24", 24, 64497),
+		vrp("192.0.2.0/24", 24, 64498),
+		vrp("192.0.2.128/25", 25, 64499),
+		vrp("192.0.2.1/32", 32, 64500),
+		vrp("2001:db8::/32", 48, 64501),
+	)
+	tests := []struct {
+		prefix string
+		want   []VRP
+	}{
+		// most specific first
+		{"192.0.2.1/32", []VRP{vrp("192.0.2.1/32", 32, 64500), vrp("192.0.2.0/24", 24, 64497), vrp("192.0.2.0/24", 24, 64498), vrp("192.0.0.0/16", 24, 64496), vrp("0.0.0.0/0", 0, 1)}},
+		{"192.0.2.0/24", []VRP{vrp("192.0.2.0/24", 24, 64497), vrp("192.0.2.0/24", 24, 64498), vrp("192.0.0.0/16", 24, 64496), vrp("0.0.0.0/0", 0, 1)}},
+		{"192.0.2.200/32", []VRP{vrp("192.0.2.128/25", 25, 64499), vrp("192.0.2.0/24", 24, 64497), vrp("192.0.2.0/24", 24, 64498), vrp("192.0.0.0/16", 24, 64496), vrp("0.0.0.0/0", 0, 1)}},
+		{"10.0.0.0/8", []VRP{vrp("0.0.0.0/0", 0, 1)}},
+		{"2001:db8:1::/48", []VRP{vrp("2001:db8::/32", 48, 64501)}},
+		{"2001:db9::/32", []VRP{}},
+		// IPv4-mapped addresses are looked up as IPv4
+		{"::ffff:192.0.2.1/128", []VRP{vrp("192.0.2.1/32", 32, 64500), vrp("192.0.2.0/24", 24, 64497), vrp("192.0.2.0/24", 24, 64498), vrp("192.0.0.0/16", 24, 64496), vrp("0.0.0.0/0", 0, 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := table.covering(netip.MustParsePrefix(tt.prefix)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("covering = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVRPTableRemove(t *testing.T) {
+	table := newStaticSource("test",
+		vrp("192.0.2.0/24", 24, 64496),
+		vrp("192.0.2.0/24", 24, 64497),
+		vrp("192.0.2.1/32", 32, 64498),
+	)
+	table.remove(vrp("192.0.2.1/32", 32, 64498))
+	table.remove(vrp("192.0.2.0/24", 24, 64496))
+	// unknown VRPs are ignored
+	table.remove(vrp("192.0.2.0/24", 24, 64511))
+	table.remove(vrp("198.51.100.0/24", 24, 64496))
+
+	if table.count != 1 {
+		t.Errorf("count = %d, want 1", table.count)
+	}
+	want := []VRP{vrp("192.0.2.0/24", 24, 64497)}
+	if got := table.covering(netip.MustParsePrefix("192.0.2.1/32")); !reflect.DeepEqual(got, want) {
+		t.Errorf("covering = %v, want %v", got, want)
+	}
+
+	// the node of the /24 is the last one left
+	node := table.v4
+	for i := 0; i < 24; i++ {
+		node = node.children[bit(netip.MustParseAddr("192.0.2.0"), i)]
+	}
+	if node.children[0] != nil || node.children[1] != nil {
+		t.Errorf("empty nodes below 192.0.2.0/24 were not pruned")
+	}
+	table.remove(vrp("192.0.2.0/24", 24, 64497))
+	if table.v4.children[0] != nil || table.v4.children[1] != nil {
+		t.Errorf("empty trie was not pruned")
+	}
+}